@@ -0,0 +1,458 @@
+package memcache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+//异步请求的结果
+type Future struct {
+	done chan struct{}
+	res  *response
+	err  error
+}
+
+func newFuture() *Future { /*{{{*/
+	return &Future{done: make(chan struct{})}
+} /*}}}*/
+
+//已失败的请求
+func failedFuture(err error) *Future { /*{{{*/
+	future := newFuture()
+	future.complete(nil, err)
+	return future
+} /*}}}*/
+
+func (this *Future) complete(res *response, err error) { /*{{{*/
+	this.res, this.err = res, err
+	close(this.done)
+} /*}}}*/
+
+//请求完成时关闭
+func (this *Future) Done() <-chan struct{} { /*{{{*/
+	return this.done
+} /*}}}*/
+
+//等待请求完成，返回值同Get；Set、Delete的value为nil，Set的cas为服务端生成的新cas
+//ctx取消时返回ctx.Err()，请求仍会在后台完成
+func (this *Future) Wait(ctx context.Context) (value interface{}, cas uint64, err error) { /*{{{*/
+	select {
+	case <-this.done:
+		if this.res == nil {
+			return nil, 0, this.err
+		}
+		return this.res.body, this.res.header.cas, this.err
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+} /*}}}*/
+
+//同步等待，返回原始响应
+func (this *Future) wait() (res *response, err error) { /*{{{*/
+	<-this.done
+	return this.res, this.err
+} /*}}}*/
+
+type asyncRequest struct {
+	opcode opcode_t
+	key    string
+	value  interface{}
+	format []interface{}
+	expire uint32
+	cas    uint64
+	future *Future
+	call   opCall
+}
+
+//连接出错时无法确定请求是否已经执行，同retryableMulti只重试重复执行结果不变的请求
+//Get及不带cas的Set可以重试；Add、Replace、Delete及带cas的命令已执行时重试会返回ErrKeyExists、ErrNotFound
+func (this *asyncRequest) retryable() bool { /*{{{*/
+	switch this.opcode {
+	case OP_GET:
+		return true
+	case OP_SET:
+		return this.cas == 0
+	}
+	return false
+} /*}}}*/
+
+//每个server一个写协程，将排队的请求合并写入同一个连接后flush一次，再按opaque取回各自的响应
+type batchWriter struct {
+	mc       *Memcache
+	server   *Server
+	requests chan *asyncRequest
+	closed   chan struct{}
+
+	mu        sync.Mutex
+	is_closed bool //关闭后不再接收请求，与入队使用mu保证原子性
+}
+
+var (
+	asyncQueueSize   = 4096
+	asyncMaxBatchCnt = 256
+)
+
+//开启请求合并，Get、Set、Add、Replace、Delete也通过每个server的写协程发送，
+//并发请求合并写入后只flush一次，减少系统调用
+//window为收到第一个请求后等待更多请求的时间，max_cnt为每批最大请求数，max_cnt为0时关闭
+func (this *Memcache) SetBatching(window time.Duration, max_cnt int) { /*{{{*/
+	if max_cnt < 0 {
+		max_cnt = 0
+	}
+	this.batchWindow.Store(int64(window))
+	this.batchMaxCnt.Store(int64(max_cnt))
+} /*}}}*/
+
+//异步Get，存储的value为map、结构体时结果反序列化到format
+func (this *Memcache) GetAsync(key string, format ...interface{}) *Future { /*{{{*/
+	key, err := this.storageKey(OP_GET, key)
+	if err != nil {
+		return failedFuture(err)
+	}
+
+	return this.sendAsync(&asyncRequest{opcode: OP_GET, key: key, format: format})
+} /*}}}*/
+
+//异步Set，参数同Set
+func (this *Memcache) SetAsync(key string, value interface{}, expire ...uint32) *Future { /*{{{*/
+	key, err := this.storageKey(OP_SET, key)
+	if err != nil {
+		return failedFuture(err)
+	}
+
+	req := &asyncRequest{opcode: OP_SET, key: key, value: value}
+	if len(expire) > 0 {
+		req.expire = expire[0]
+	}
+	if err := checkExpire(req.expire); err != nil {
+		return failedFuture(newOpError(OP_SET, key, nil, err))
+	}
+	return this.sendAsync(req)
+} /*}}}*/
+
+//异步Delete，参数同Delete
+func (this *Memcache) DeleteAsync(key string, cas ...uint64) *Future { /*{{{*/
+	key, err := this.storageKey(OP_DELETE, key)
+	if err != nil {
+		return failedFuture(err)
+	}
+
+	req := &asyncRequest{opcode: OP_DELETE, key: key}
+	if len(cas) > 0 {
+		req.cas = cas[0]
+	}
+	return this.sendAsync(req)
+} /*}}}*/
+
+func (this *Memcache) sendAsync(req *asyncRequest) *Future { /*{{{*/
+	req.future = newFuture()
+
+	//耗时包含排队等待的时间
+	req.call = this.begin(this.ctx, req.opcode, req.key, nil)
+	this.dispatch(req)
+
+	return req.future
+} /*}}}*/
+
+//按key选择server并加入对应写协程的队列，写协程已关闭(server被移除)时按新的server列表重新选择
+func (this *Memcache) dispatch(req *asyncRequest) { /*{{{*/
+	for ; req.call.tries < badTryCnt; req.call.tries++ {
+		//在读锁内创建写协程，SetServers切换后关闭的写协程不会再被创建
+		var writer *batchWriter
+		this.nodes_lock.RLock()
+		server := this.nodes.getServerByKey(req.key)
+		if server != nil {
+			writer = this.getBatchWriter(server)
+		}
+		this.nodes_lock.RUnlock()
+
+		req.call.server = server
+		if server == nil {
+			this.completeAsync(req, nil, newOpError(req.opcode, req.key, nil, ErrNotConn))
+			return
+		}
+		if writer == nil {
+			//已调用Close
+			this.completeAsync(req, nil, newOpError(req.opcode, req.key, server, ErrBadConn))
+			return
+		}
+		if writer.enqueue(req) {
+			return
+		}
+	}
+	this.completeAsync(req, nil, newOpError(req.opcode, req.key, req.call.server, ErrBadConn))
+} /*}}}*/
+
+//Close后返回nil
+func (this *Memcache) getBatchWriter(server *Server) *batchWriter { /*{{{*/
+	this.writerLock.Lock()
+	defer this.writerLock.Unlock()
+
+	if this.writersClosed {
+		return nil
+	}
+	if this.writers == nil {
+		this.writers = make(map[*Server]*batchWriter)
+	}
+	writer, ok := this.writers[server]
+	if !ok {
+		writer = &batchWriter{
+			mc:       this,
+			server:   server,
+			requests: make(chan *asyncRequest, asyncQueueSize),
+			closed:   make(chan struct{}),
+		}
+		this.writers[server] = writer
+		go writer.loop()
+	}
+	return writer
+} /*}}}*/
+
+func (this *Memcache) closeBatchWriters() { /*{{{*/
+	this.writerLock.Lock()
+	defer this.writerLock.Unlock()
+
+	this.writersClosed = true
+	for server, writer := range this.writers {
+		writer.close()
+		delete(this.writers, server)
+	}
+} /*}}}*/
+
+//server被移除时关闭对应的写协程
+func (this *Memcache) closeBatchWriter(server *Server) { /*{{{*/
+	this.writerLock.Lock()
+	defer this.writerLock.Unlock()
+
+	if writer, ok := this.writers[server]; ok {
+		writer.close()
+		delete(this.writers, server)
+	}
+} /*}}}*/
+
+//加入发送队列，已关闭时返回false
+//检查关闭状态与入队在同一个锁内完成，关闭后不会再有请求入队，drain可以取出全部未处理的请求
+func (this *batchWriter) enqueue(req *asyncRequest) bool { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.is_closed {
+		return false
+	}
+	this.requests <- req
+	return true
+} /*}}}*/
+
+func (this *batchWriter) close() { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.is_closed = true
+	close(this.closed)
+} /*}}}*/
+
+func (this *batchWriter) loop() { /*{{{*/
+	batch := make([]*asyncRequest, 0, asyncMaxBatchCnt)
+
+	for {
+		select {
+		case req := <-this.requests:
+			batch = append(batch[:0], req)
+		case <-this.closed:
+			this.drain()
+			return
+		}
+
+		//SetBatching可能在运行中修改，每批重新读取
+		max_cnt := asyncMaxBatchCnt
+		if n := int(this.mc.batchMaxCnt.Load()); n > 0 {
+			max_cnt = n
+		}
+		window := time.Duration(this.mc.batchWindow.Load())
+
+		//取出已经排队的请求，合并为一批
+	collect:
+		for len(batch) < max_cnt {
+			select {
+			case req := <-this.requests:
+				batch = append(batch, req)
+			default:
+				break collect
+			}
+		}
+
+		//在window内继续等待其它请求，直到达到每批最大请求数
+		if window > 0 && len(batch) < max_cnt {
+			timer := time.NewTimer(window)
+		wait:
+			for len(batch) < max_cnt {
+				select {
+				case req := <-this.requests:
+					batch = append(batch, req)
+				case <-timer.C:
+					break wait
+				}
+			}
+			timer.Stop()
+		}
+
+		this.process(batch)
+	}
+} /*}}}*/
+
+//关闭后未处理的请求按新的server列表重新发送，Close后返回ErrBadConn
+func (this *batchWriter) drain() { /*{{{*/
+	for {
+		select {
+		case req := <-this.requests:
+			req.call.tries++
+			this.mc.dispatch(req)
+		default:
+			return
+		}
+	}
+} /*}}}*/
+
+func (this *batchWriter) process(batch []*asyncRequest) { /*{{{*/
+	this.mc.nodes_lock.RLock()
+	defer this.mc.nodes_lock.RUnlock()
+
+	for len(batch) > 0 {
+		batch = this.send(batch)
+	}
+} /*}}}*/
+
+//使用一个连接发送一批请求，连接断开时返回需要使用新连接重试的请求
+func (this *batchWriter) send(batch []*asyncRequest) (retry []*asyncRequest) { /*{{{*/
+	server := this.server
+	if server.pool == nil {
+		this.fail(batch, ErrNotConn)
+		return nil
+	}
+
+	conn, e := server.pool.Get()
+	if e != nil {
+		this.mc.sendBadServerNotice()
+		this.fail(batch, e)
+		return nil
+	}
+
+	sent := 0
+	pending := make([]*asyncRequest, len(batch))
+	for i, req := range batch {
+		if err := conn.writeAsyncRequest(req, uint32(i)); err != nil {
+			this.mc.completeAsync(req, nil, conn.opError(req.opcode, req.key, 0, err))
+			continue
+		}
+		pending[i] = req
+		sent++
+	}
+
+	if sent == 0 {
+		server.pool.Put(conn)
+		return nil
+	}
+
+	if err := conn.flushBufferToServer(); err != nil {
+		server.pool.Release(conn)
+		return this.retry(pending, ErrBadConn)
+	}
+
+	for ; sent > 0; sent-- {
+		resp, err := conn.readResponse()
+		if err != nil {
+			//连接已不可用，只有未收到响应的请求需要重试
+			server.pool.Release(conn)
+			return this.retry(pending, err)
+		}
+
+		if int(resp.header.opaque) >= len(pending) || pending[resp.header.opaque] == nil {
+			continue
+		}
+		req := pending[resp.header.opaque]
+		pending[resp.header.opaque] = nil
+
+		this.mc.completeAsync(req, resp, conn.parseAsyncResponse(req, resp))
+	}
+
+	server.pool.Put(conn)
+	return nil
+} /*}}}*/
+
+//ErrBadConn时可以重复执行且未超过重试次数的请求返回重新发送，其它请求失败
+func (this *batchWriter) retry(pending []*asyncRequest, err error) (retry []*asyncRequest) { /*{{{*/
+	for _, req := range pending {
+		if req == nil {
+			continue
+		}
+		req.call.tries++
+		if errors.Is(err, ErrBadConn) && req.retryable() && req.call.tries < badTryCnt {
+			retry = append(retry, req)
+		} else {
+			this.mc.completeAsync(req, nil, newOpError(req.opcode, req.key, this.server, err))
+		}
+	}
+	return retry
+} /*}}}*/
+
+func (this *batchWriter) fail(batch []*asyncRequest, err error) { /*{{{*/
+	for _, req := range batch {
+		if req != nil {
+			this.mc.completeAsync(req, nil, newOpError(req.opcode, req.key, this.server, err))
+		}
+	}
+} /*}}}*/
+
+//完成请求，先调用hook、Tracer再唤醒等待的goroutine
+func (this *Memcache) completeAsync(req *asyncRequest, res *response, err error) { /*{{{*/
+	this.finish(&req.call, &err)
+	req.future.complete(res, err)
+} /*}}}*/
+
+func (this *Connection) writeAsyncRequest(req *asyncRequest, opaque uint32) error { /*{{{*/
+	switch req.opcode {
+	case OP_SET, OP_ADD, OP_REPLACE:
+		val, data_type := this.getValueTypeByte(req.value)
+		if val == nil {
+			return ErrInvalValue
+		}
+		req.call.size = len(val)
+		extra_byte := this.extra_buf[:8]
+		binary.BigEndian.PutUint32(extra_byte[0:4], uint32(data_type))
+		binary.BigEndian.PutUint32(extra_byte[4:8], req.expire)
+		return this.writeRequest(req.opcode, req.key, extra_byte, val, req.cas, opaque)
+	case OP_GET, OP_DELETE:
+		return this.writeRequest(req.opcode, req.key, nil, nil, req.cas, opaque)
+	default:
+		return ErrCmd
+	}
+} /*}}}*/
+
+//解析响应，Get的value反序列化到resp.body
+func (this *Connection) parseAsyncResponse(req *asyncRequest, resp *response) error { /*{{{*/
+	if err := this.checkResponseError(resp.header.status); err != nil {
+		return this.opError(req.opcode, req.key, resp.header.status, err)
+	}
+	if req.opcode != OP_GET {
+		return nil
+	}
+	if resp.header.extlen < 4 {
+		return this.opError(req.opcode, req.key, resp.header.status, ErrUnkown)
+	}
+
+	resp.flags = value_type_t(binary.BigEndian.Uint32(resp.bodyByte[:resp.header.extlen]))
+	if resp.flags == VALUE_TYPE_NEGATIVE {
+		return this.opError(req.opcode, req.key, resp.header.status, ErrNotFound)
+	}
+
+	req.call.size = len(resp.value())
+	value, err := formatValueFromByte(resp.flags, resp.value(), req.format...)
+	if err != nil {
+		return this.opError(req.opcode, req.key, resp.header.status, err)
+	}
+	resp.body = value
+	return nil
+} /*}}}*/
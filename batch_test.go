@@ -0,0 +1,235 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//并发请求合并发送，响应按opaque分发给各自的请求
+func TestBatchingOpaque(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+	mc.SetBatching(time.Millisecond*2, 64)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "key_" + strconv.Itoa(i)
+			if _, err := mc.Set(key, i); err != nil {
+				t.Error(err)
+				return
+			}
+			value, _, err := mc.Get(key)
+			if err != nil || value != i {
+				t.Errorf("Get %s = %v, %v, want %d", key, value, err, i)
+			}
+			if _, _, err := mc.Get("missing_" + strconv.Itoa(i)); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get missing err = %v, want ErrNotFound", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	//合并发送后请求数不变，每个请求一个响应
+	fs[0].mu.Lock()
+	ops := fs[0].ops
+	fs[0].mu.Unlock()
+	if ops != 300 {
+		t.Errorf("server received %d requests, want 300", ops)
+	}
+
+	//同一批中写入失败的请求不影响其它请求
+	f1 := mc.SetAsync("bad", make(chan int))
+	f2 := mc.GetAsync("key_1")
+	if _, err := f1.wait(); !errors.Is(err, ErrInvalValue) {
+		t.Errorf("invalid value err = %v, want ErrInvalValue", err)
+	}
+	if res, err := f2.wait(); err != nil || res.body != 1 {
+		t.Errorf("GetAsync = %v, %v", res, err)
+	}
+} /*}}}*/
+
+//连接断开时只重试Get及不带cas的Set，其它命令可能已经执行，重试会返回错误的结果
+func TestBatchingRetry(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+	mc.SetBatching(0, 16)
+
+	if _, err := mc.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	//连接池中的连接已被server关闭，Get、Set使用新连接重试
+	fs[0].dropConns()
+	if value, _, err := mc.Get("key"); err != nil || value != "value" {
+		t.Fatalf("Get after server closed connections = %v, %v", value, err)
+	}
+	fs[0].dropConns()
+	if _, err := mc.Set("key", "new"); err != nil {
+		t.Fatalf("Set after server closed connections: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{"add", func() error { _, err := mc.Add("add", "value"); return err }},
+		{"replace", func() error { _, err := mc.Replace("key", "replaced"); return err }},
+		{"delete", func() error { _, err := mc.Delete("key"); return err }},
+	}
+	for _, tt := range tests {
+		//命令已执行但没有收到响应
+		fs[0].mu.Lock()
+		fs[0].dropReply = 1
+		ops := fs[0].ops
+		fs[0].mu.Unlock()
+
+		err := tt.op()
+		if !errors.Is(err, ErrBadConn) {
+			t.Errorf("%s: err = %v, want ErrBadConn", tt.name, err)
+		}
+		fs[0].mu.Lock()
+		sent := fs[0].ops - ops
+		fs[0].mu.Unlock()
+		if sent != 1 {
+			t.Errorf("%s: sent %d times, want 1", tt.name, sent)
+		}
+	}
+
+	if value, _, err := mc.Get("add"); err != nil || value != "value" {
+		t.Errorf("Get add = %v, %v", value, err)
+	}
+	if _, _, err := mc.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get deleted key err = %v, want ErrNotFound", err)
+	}
+} /*}}}*/
+
+//接收连接但不返回响应，stop关闭监听及已建立的连接
+func newSilentServer(t *testing.T) (addr string, stop func()) { /*{{{*/
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			go io.Copy(io.Discard, c)
+		}
+	}()
+	stop = func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+		conns = nil
+	}
+	t.Cleanup(stop)
+	return ln.Addr().String(), stop
+} /*}}}*/
+
+//ctx取消时Wait返回ctx.Err()，请求仍在后台完成
+func TestFutureWaitCanceled(t *testing.T) { /*{{{*/
+	addr, stop := newSilentServer(t)
+	mc, err := NewMemcache([]*Server{{Address: addr, InitConn: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+
+	future := mc.GetAsync("key")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := future.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait err = %v, want Canceled", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, _, err := future.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait err = %v, want DeadlineExceeded", err)
+	}
+
+	//server关闭后请求以错误完成
+	stop()
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second * 2):
+		t.Fatal("future not completed after server stopped")
+	}
+	//Get使用新连接重试，server已关闭时连接失败
+	if _, _, err := future.Wait(context.Background()); !errors.Is(err, ErrNotConn) {
+		t.Fatalf("Wait after server stopped err = %v, want ErrNotConn", err)
+	}
+} /*}}}*/
+
+func TestFutureError(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+	ctx := context.Background()
+
+	var op_err *OpError
+	if _, _, err := mc.DeleteAsync("missing").Wait(ctx); !errors.Is(err, ErrNotFound) || !errors.As(err, &op_err) || op_err.Key != "missing" {
+		t.Errorf("DeleteAsync missing err = %v, want ErrNotFound", err)
+	}
+	if _, _, err := mc.GetAsync("missing").Wait(ctx); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAsync missing err = %v, want ErrNotFound", err)
+	}
+	if _, _, err := mc.GetAsync("bad key").Wait(ctx); !errors.Is(err, ErrMalformedKey) {
+		t.Errorf("GetAsync malformed key err = %v, want ErrMalformedKey", err)
+	}
+	if _, _, err := mc.SetAsync("key", "value", uint32(time.Now().Unix()-60)).Wait(ctx); !errors.Is(err, ErrInvalExpire) {
+		t.Errorf("SetAsync past expire err = %v, want ErrInvalExpire", err)
+	}
+
+	value, cas, err := mc.SetAsync("key", "value").Wait(ctx)
+	if err != nil || value != nil || cas == 0 {
+		t.Fatalf("SetAsync = %v, %d, %v", value, cas, err)
+	}
+	if _, _, err := mc.DeleteAsync("key", cas+1).Wait(ctx); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("DeleteAsync with stale cas err = %v, want ErrKeyExists", err)
+	}
+	if value, got_cas, err := mc.GetAsync("key").Wait(ctx); err != nil || value != "value" || got_cas != cas {
+		t.Errorf("GetAsync = %v, %d, %v", value, got_cas, err)
+	}
+} /*}}}*/
+
+//Close后排队中的请求全部完成，之后的请求返回ErrBadConn
+func TestFutureAfterClose(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 2)
+	mc.SetBatching(time.Millisecond*5, 8)
+
+	var futures []*Future
+	for i := 0; i < 200; i++ {
+		futures = append(futures, mc.SetAsync("key_"+strconv.Itoa(i), i))
+	}
+	mc.Close()
+
+	for i, future := range futures {
+		select {
+		case <-future.Done():
+		case <-time.After(time.Second * 2):
+			t.Fatalf("future %d not completed after Close", i)
+		}
+		if _, _, err := future.Wait(context.Background()); err != nil && !errors.Is(err, ErrBadConn) {
+			t.Errorf("future %d err = %v, want nil or ErrBadConn", i, err)
+		}
+	}
+
+	if _, _, err := mc.GetAsync("key_1").Wait(context.Background()); !errors.Is(err, ErrBadConn) {
+		t.Fatalf("GetAsync after Close err = %v, want ErrBadConn", err)
+	}
+} /*}}}*/
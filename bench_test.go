@@ -0,0 +1,162 @@
+package memcache
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+//各命令每次调用的内存分配：go test -run=NONE -bench=. -benchmem
+//连接使用cannedConn，同步返回预先生成的响应，没有server协程，统计的只有client的内存分配
+
+const benchValue = "hello world value"
+
+//按opcode返回固定的响应，key以miss开头的GET返回未命中
+type cannedConn struct {
+	resp map[opcode_t][]byte
+	miss []byte
+
+	rbuf []byte //待读取的响应
+	rpos int
+}
+
+func newCannedConn() *cannedConn { /*{{{*/
+	str_flags := make([]byte, 4)
+	binary.BigEndian.PutUint32(str_flags, uint32(VALUE_TYPE_STRING))
+
+	return &cannedConn{
+		resp: map[opcode_t][]byte{
+			OP_GET:    cannedResponse(OP_GET, STATUS_SUCCESS, str_flags, []byte(benchValue)),
+			OP_SET:    cannedResponse(OP_SET, STATUS_SUCCESS, nil, nil),
+			OP_DELETE: cannedResponse(OP_DELETE, STATUS_SUCCESS, nil, nil),
+		},
+		miss: cannedResponse(OP_GET, STATUS_KEY_ENOENT, nil, []byte("Not found")),
+	}
+} /*}}}*/
+
+func cannedResponse(opcode opcode_t, status status_t, extra []byte, value []byte) []byte { /*{{{*/
+	b := make([]byte, 24+len(extra)+len(value))
+	b[0] = byte(MAGIC_RES)
+	b[1] = byte(opcode)
+	b[4] = byte(len(extra))
+	binary.BigEndian.PutUint16(b[6:8], uint16(status))
+	binary.BigEndian.PutUint32(b[8:12], uint32(len(extra)+len(value)))
+	binary.BigEndian.PutUint64(b[16:24], 1)
+	copy(b[24:], extra)
+	copy(b[24+len(extra):], value)
+	return b
+} /*}}}*/
+
+//解析写入的请求并追加对应的响应，opaque与请求一致
+func (this *cannedConn) Write(p []byte) (int, error) { /*{{{*/
+	if this.rpos == len(this.rbuf) {
+		this.rbuf, this.rpos = this.rbuf[:0], 0
+	}
+
+	for b := p; len(b) >= 24; {
+		opcode := opcode_t(b[1])
+		keylen := int(binary.BigEndian.Uint16(b[2:4]))
+		extlen := int(b[4])
+		bodylen := int(binary.BigEndian.Uint32(b[8:12]))
+		key := b[24+extlen : 24+extlen+keylen]
+
+		resp := this.resp[opcode]
+		if opcode == OP_GET && len(key) >= 4 && string(key[:4]) == "miss" {
+			resp = this.miss
+		}
+		start := len(this.rbuf)
+		this.rbuf = append(this.rbuf, resp...)
+		copy(this.rbuf[start+12:start+16], b[12:16])
+
+		b = b[24+bodylen:]
+	}
+	return len(p), nil
+} /*}}}*/
+
+func (this *cannedConn) Read(p []byte) (int, error) { /*{{{*/
+	n := copy(p, this.rbuf[this.rpos:])
+	this.rpos += n
+	return n, nil
+} /*}}}*/
+
+func (this *cannedConn) Close() error                       { return nil }
+func (this *cannedConn) LocalAddr() net.Addr                { return nil }
+func (this *cannedConn) RemoteAddr() net.Addr               { return nil }
+func (this *cannedConn) SetDeadline(t time.Time) error      { return nil }
+func (this *cannedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (this *cannedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func newBenchMemcache(b *testing.B) *Memcache { /*{{{*/
+	server := &Server{
+		Address:  "127.0.0.1:11211",
+		InitConn: 1,
+		MaxConn:  1,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return newCannedConn(), nil
+		},
+	}
+	mc, err := NewMemcache([]*Server{server})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(mc.Close)
+	b.ReportAllocs()
+	return mc
+} /*}}}*/
+
+func BenchmarkGetString(b *testing.B) { /*{{{*/
+	mc := newBenchMemcache(b)
+	for i := 0; i < b.N; i++ {
+		if _, _, err := mc.Get("bench_key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+} /*}}}*/
+
+func BenchmarkGetMiss(b *testing.B) { /*{{{*/
+	mc := newBenchMemcache(b)
+	for i := 0; i < b.N; i++ {
+		mc.Get("miss_key")
+	}
+} /*}}}*/
+
+func BenchmarkGetInto(b *testing.B) { /*{{{*/
+	mc := newBenchMemcache(b)
+	buf := make([]byte, 0, 64)
+	for i := 0; i < b.N; i++ {
+		value, _, err := mc.GetInto("bench_key", buf)
+		if err != nil || string(value) != benchValue {
+			b.Fatal(string(value), err)
+		}
+		buf = value
+	}
+} /*}}}*/
+
+func BenchmarkSetString(b *testing.B) { /*{{{*/
+	mc := newBenchMemcache(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := mc.Set("bench_key", benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+} /*}}}*/
+
+func BenchmarkSetInt64(b *testing.B) { /*{{{*/
+	mc := newBenchMemcache(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := mc.Set("bench_key", int64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+} /*}}}*/
+
+func BenchmarkDelete(b *testing.B) { /*{{{*/
+	mc := newBenchMemcache(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := mc.Delete("bench_key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+} /*}}}*/
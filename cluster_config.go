@@ -0,0 +1,206 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ElastiCache等支持"config get cluster"命令的配置节点，返回格式：
+//
+//	CONFIG cluster 0 <bytes>\r\n
+//	<version>\n
+//	host|ip|port host|ip|port ...\n
+//	\r\n
+//	END\r\n
+type ClusterConfigOptions struct {
+	Endpoint string        //配置节点地址host:port
+	Interval time.Duration //轮询间隔，默认30s，小于0时不轮询
+	Timeout  time.Duration //每次查询的超时时间，默认5s
+
+	//发现的server使用Template的MaxConn、InitConn、IdleTime、TLSConfig、Dialer、Weight
+	//连接配置节点同样使用Template的TLSConfig、Dialer
+	Template *Server
+}
+
+//通过配置节点获取server列表并创建Memcache，之后按Interval轮询，配置版本号变化时更新server列表
+func NewMemcacheFromClusterConfig(opts *ClusterConfigOptions) (mem *Memcache, err error) { /*{{{*/
+	if opts == nil || opts.Endpoint == "" {
+		return nil, ErrInval
+	}
+
+	version, server_list, err := opts.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err = NewMemcache(server_list)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := opts.Interval
+	if interval == 0 {
+		interval = defaultDiscoveryInterval
+	}
+	if interval > 0 {
+		refresher := &clusterRefresher{opts: opts, version: version}
+		go mem.refreshServers(interval, refresher.lookup, refresher.applied)
+	}
+
+	return mem, nil
+} /*}}}*/
+
+//记录已应用的配置版本号，server列表更新成功后才更新版本号，更新失败时下次轮询重试
+type clusterRefresher struct {
+	opts    *ClusterConfigOptions
+	version int64 //已应用的版本号
+	pending int64 //最近一次查询到的版本号
+}
+
+func (this *clusterRefresher) lookup() ([]*Server, error) { /*{{{*/
+	v, server_list, err := this.opts.lookup()
+	if err != nil || v == this.version {
+		//版本号未变化时返回空列表，不更新
+		return nil, err
+	}
+	this.pending = v
+	return server_list, nil
+} /*}}}*/
+
+func (this *clusterRefresher) applied() { /*{{{*/
+	this.version = this.pending
+} /*}}}*/
+
+func (this *ClusterConfigOptions) lookup() (version int64, server_list []*Server, err error) { /*{{{*/
+	template := this.Template
+	if template == nil {
+		template = &Server{}
+	}
+
+	conn, err := connect(this.Endpoint, template.Dialer, template.TLSConfig)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer conn.Close()
+
+	timeout := this.Timeout
+	if timeout <= 0 {
+		timeout = defaultDiscoveryTimeout
+	}
+	conn.c.SetDeadline(time.Now().Add(timeout))
+
+	conn.buffered.WriteString("config get cluster\r\n")
+	if err := conn.buffered.Flush(); err != nil {
+		return 0, nil, ErrBadConn
+	}
+
+	body, err := readClusterConfig(conn.buffered.Reader)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	version, nodes, err := parseClusterConfig(body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, address := range nodes {
+		server_list = append(server_list, &Server{
+			Address:   address,
+			Weight:    template.Weight,
+			MaxConn:   template.MaxConn,
+			InitConn:  template.InitConn,
+			IdleTime:  template.IdleTime,
+			TLSConfig: template.TLSConfig,
+			Dialer:    template.Dialer,
+		})
+	}
+
+	return version, server_list, nil
+} /*}}}*/
+
+//CONFIG响应数据部分的长度上限，节点列表远小于该值
+const maxClusterConfigLen = 1024 * 1024
+
+//读取CONFIG响应的数据部分
+func readClusterConfig(r *bufio.Reader) (body []byte, err error) { /*{{{*/
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, ErrBadConn
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	//不支持该命令时返回ERROR
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[0] != "CONFIG" {
+		return nil, fmt.Errorf("%w: %s", ErrClusterConfig, line)
+	}
+	n, err := strconv.Atoi(fields[3])
+	if err != nil || n < 0 {
+		return nil, ErrClusterConfig
+	}
+	//长度来自网络，超过上限时不分配内存
+	if n > maxClusterConfigLen {
+		return nil, ErrInvalFormat
+	}
+
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrBadConn
+	}
+
+	//数据之后为\r\nEND\r\n
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, ErrBadConn
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "END" {
+			break
+		}
+		if line != "" {
+			return nil, ErrClusterConfig
+		}
+	}
+
+	return body, nil
+} /*}}}*/
+
+//解析版本号及节点列表，节点格式为host|ip|port，ip为空时使用host
+func parseClusterConfig(body []byte) (version int64, nodes []string, err error) { /*{{{*/
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) < 2 {
+		return 0, nil, ErrClusterConfig
+	}
+
+	version, err = strconv.ParseInt(strings.TrimSpace(lines[0]), 10, 64)
+	if err != nil {
+		return 0, nil, ErrClusterConfig
+	}
+
+	for _, node := range strings.Fields(lines[1]) {
+		parts := strings.Split(node, "|")
+		if len(parts) != 3 {
+			return 0, nil, ErrClusterConfig
+		}
+		host := parts[1]
+		if host == "" {
+			host = parts[0]
+		}
+		if _, err := strconv.ParseUint(parts[2], 10, 16); err != nil || host == "" {
+			return 0, nil, ErrClusterConfig
+		}
+		nodes = append(nodes, net.JoinHostPort(host, parts[2]))
+	}
+
+	if len(nodes) == 0 {
+		return 0, nil, ErrClusterConfig
+	}
+	return version, nodes, nil
+} /*}}}*/
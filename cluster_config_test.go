@@ -0,0 +1,208 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//模拟配置节点，只支持config get cluster，其它命令返回ERROR
+type configServer struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	version int64
+	nodes   []string
+}
+
+func newConfigServer(t *testing.T, version int64, nodes ...string) *configServer { /*{{{*/
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &configServer{ln: ln}
+	cs.set(version, nodes...)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go cs.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return cs
+} /*}}}*/
+
+func (this *configServer) set(version int64, nodes ...string) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.version = version
+	this.nodes = nodes
+} /*}}}*/
+
+func (this *configServer) serve(c net.Conn) { /*{{{*/
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(line) != "config get cluster" {
+			fmt.Fprint(c, "ERROR\r\n")
+			continue
+		}
+		this.mu.Lock()
+		body := fmt.Sprintf("%d\n%s\n", this.version, strings.Join(this.nodes, " "))
+		this.mu.Unlock()
+		fmt.Fprintf(c, "CONFIG cluster 0 %d\r\n%s\r\nEND\r\n", len(body), body)
+	}
+} /*}}}*/
+
+func (this *configServer) addr() string { /*{{{*/
+	return this.ln.Addr().String()
+} /*}}}*/
+
+//fakeServer对应的节点，格式为host|ip|port
+func clusterNode(f *fakeServer) string { /*{{{*/
+	host, port, _ := net.SplitHostPort(f.addr())
+	return "node.cache.local|" + host + "|" + port
+} /*}}}*/
+
+func TestParseClusterConfig(t *testing.T) { /*{{{*/
+	tests := []struct {
+		name    string
+		body    string
+		version int64
+		nodes   []string
+		err     bool
+	}{
+		{
+			name:    "ip",
+			body:    "12\nmc1.cache.local|10.0.0.1|11211 mc2.cache.local|10.0.0.2|11212\n",
+			version: 12,
+			nodes:   []string{"10.0.0.1:11211", "10.0.0.2:11212"},
+		},
+		{
+			name:    "empty ip uses host",
+			body:    "3\nmc1.cache.local||11211\n\r\n",
+			version: 3,
+			nodes:   []string{"mc1.cache.local:11211"},
+		},
+		{
+			name:    "ipv6",
+			body:    "1\nmc1.cache.local|::1|11211\n",
+			version: 1,
+			nodes:   []string{"[::1]:11211"},
+		},
+		{name: "empty", body: "", err: true},
+		{name: "no nodes", body: "1\n\n", err: true},
+		{name: "bad version", body: "v1\nmc1|10.0.0.1|11211\n", err: true},
+		{name: "bad node", body: "1\nmc1|10.0.0.1\n", err: true},
+		{name: "bad port", body: "1\nmc1|10.0.0.1|70000\n", err: true},
+		{name: "no host", body: "1\n||11211\n", err: true},
+	}
+
+	for _, tt := range tests {
+		version, nodes, err := parseClusterConfig([]byte(tt.body))
+		if tt.err {
+			if !errors.Is(err, ErrClusterConfig) {
+				t.Errorf("%s: err = %v, want ErrClusterConfig", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if version != tt.version || strings.Join(nodes, ",") != strings.Join(tt.nodes, ",") {
+			t.Errorf("%s: got %d %v, want %d %v", tt.name, version, nodes, tt.version, tt.nodes)
+		}
+	}
+} /*}}}*/
+
+func TestClusterConfigUnsupported(t *testing.T) { /*{{{*/
+	//普通memcached不支持该命令
+	_, err := readClusterConfig(bufio.NewReader(strings.NewReader("ERROR\r\n")))
+	if !errors.Is(err, ErrClusterConfig) {
+		t.Fatalf("err = %v, want ErrClusterConfig", err)
+	}
+} /*}}}*/
+
+func TestClusterConfigTooLarge(t *testing.T) { /*{{{*/
+	resp := fmt.Sprintf("CONFIG cluster 0 %d\r\n", maxClusterConfigLen+1)
+	if _, err := readClusterConfig(bufio.NewReader(strings.NewReader(resp))); !errors.Is(err, ErrInvalFormat) {
+		t.Fatalf("err = %v, want ErrInvalFormat", err)
+	}
+
+	body := "12\nnode|127.0.0.1|11211\n"
+	resp = fmt.Sprintf("CONFIG cluster 0 %d\r\n%s\r\nEND\r\n", len(body), body)
+	if got, err := readClusterConfig(bufio.NewReader(strings.NewReader(resp))); err != nil || string(got) != body {
+		t.Fatalf("readClusterConfig = %q, %v", got, err)
+	}
+} /*}}}*/
+
+//server列表更新失败时不记录版本号，下次轮询重新应用
+func TestClusterRefresherVersion(t *testing.T) { /*{{{*/
+	f1, f2 := newFake(t), newFake(t)
+	cs := newConfigServer(t, 1, clusterNode(f1))
+	refresher := &clusterRefresher{opts: &ClusterConfigOptions{Endpoint: cs.addr()}, version: 1}
+
+	list, err := refresher.lookup()
+	if err != nil || list != nil {
+		t.Fatalf("unchanged version: %v %v", list, err)
+	}
+
+	cs.set(2, clusterNode(f2))
+	for i := 0; i < 2; i++ {
+		list, err = refresher.lookup()
+		if err != nil || len(list) != 1 || list[0].Address != f2.addr() {
+			t.Fatalf("lookup %d before applied: %v %v", i, list, err)
+		}
+	}
+
+	refresher.applied()
+	list, err = refresher.lookup()
+	if err != nil || list != nil {
+		t.Fatalf("lookup after applied: %v %v", list, err)
+	}
+} /*}}}*/
+
+func TestClusterConfigRefresh(t *testing.T) { /*{{{*/
+	f1, f2, f3 := newFake(t), newFake(t), newFake(t)
+	cs := newConfigServer(t, 1, clusterNode(f1), clusterNode(f2))
+
+	mc, err := NewMemcacheFromClusterConfig(&ClusterConfigOptions{Endpoint: cs.addr(), Interval: time.Millisecond * 10, Template: &Server{InitConn: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	if got := serverAddrs(mc); got != joinAddrs(f1, f2) {
+		t.Fatalf("servers = %s, want %s", got, joinAddrs(f1, f2))
+	}
+
+	//版本号未变化时不更新
+	cs.set(1, clusterNode(f3))
+	time.Sleep(time.Millisecond * 50)
+	if got := serverAddrs(mc); got != joinAddrs(f1, f2) {
+		t.Fatalf("servers = %s without version change, want %s", got, joinAddrs(f1, f2))
+	}
+
+	cs.set(2, clusterNode(f3))
+	waitServers(t, mc, joinAddrs(f3))
+	if _, err := mc.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	//配置节点不可用时返回错误
+	_, err = NewMemcacheFromClusterConfig(&ClusterConfigOptions{Endpoint: f1.addr(), Interval: -1, Timeout: time.Millisecond * 100})
+	if err == nil {
+		t.Fatal("binary server accepted as config endpoint")
+	}
+} /*}}}*/
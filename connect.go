@@ -3,45 +3,136 @@ package memcache
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Connection struct {
+	address        string
 	c              net.Conn
 	buffered       bufio.ReadWriter
 	lastActiveTime time.Time
+
+	header_buf [24]byte //请求、响应头的临时缓冲区
+	extra_buf  [20]byte //请求extras的临时缓冲区
+	value_buf  [20]byte //数值类型value的临时缓冲区，写入buffered后即可复用
+
+	valueLen int //最近一次请求写入或响应读取的value字节数，用于统计
+
+	log *eventLog
 }
 
+//响应body缓冲池，超过maxPooledBodySize的不回收
+var bodyBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+const maxPooledBodySize = 64 * 1024
+
+//超时时间(time.Duration)，SetTimeout可能与正在执行的命令并发，使用atomic读写
 var (
-	dialTimeout  time.Duration
-	writeTimeout time.Duration
-	readTimeout  time.Duration
+	dialTimeout  atomic.Int64
+	writeTimeout atomic.Int64
+	readTimeout  atomic.Int64
+
+	defaultKeepAlive = time.Second * 30 //默认TCP keepalive探测间隔
 )
 
-func connect(address string) (conn *Connection, err error) { /*{{{*/
-	var network string
-	if strings.Contains(address, "/") {
-		network = "unix"
-	} else {
-		network = "tcp"
+//自定义建立连接的方法，可以用于代理、设置socket选项、绑定源地址等，network为tcp或unix
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+//解析server地址：unix:/path/to/sock、包含"/"的路径为unix socket，其它为host:port(IPv6使用[::1]:11211)
+func parseAddress(address string) (network string, addr string, err error) { /*{{{*/
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		network, addr = "unix", strings.TrimPrefix(address, "unix:")
+		if addr == "" {
+			return "", "", ErrInvalAddress
+		}
+	case strings.Contains(address, "/"):
+		network, addr = "unix", address
+	default:
+		//IPv6需要使用[]，::1:11211这类无法区分端口的写法返回错误
+		if _, port, e := net.SplitHostPort(address); e != nil || port == "" {
+			return "", "", ErrInvalAddress
+		}
+		network, addr = "tcp", address
 	}
-	var nc net.Conn
+	return network, addr, nil
+} /*}}}*/
 
-	if dialTimeout > 0 {
-		nc, err = net.DialTimeout(network, address, dialTimeout)
+func connect(address string, dialer DialFunc, tls_config *tls.Config) (conn *Connection, err error) { /*{{{*/
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotConn, err)
+	}
+
+	dial_timeout := time.Duration(dialTimeout.Load())
+
+	var nc net.Conn
+	if dialer != nil {
+		ctx := context.Background()
+		if dial_timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, dial_timeout)
+			defer cancel()
+		}
+		nc, err = dialer(ctx, network, addr)
 	} else {
-		nc, err = net.Dial(network, address)
+		d := &net.Dialer{Timeout: dial_timeout, KeepAlive: defaultKeepAlive}
+		nc, err = d.Dial(network, addr)
 	}
 	if err != nil {
-		return nil, ErrNotConn
+		//保留dial的错误信息，仍可以用errors.Is(err, ErrNotConn)判断
+		return nil, fmt.Errorf("%w: %v", ErrNotConn, err)
+	}
+
+	if tls_config != nil {
+		if nc, err = tlsHandshake(nc, network, addr, tls_config); err != nil {
+			return nil, err
+		}
+	}
+
+	conn = newConnection(nc)
+	conn.address = address
+	return conn, nil
+} /*}}}*/
+
+//在已建立的连接上完成TLS握手，握手超时使用dialTimeout，失败时返回*TLSError
+func tlsHandshake(nc net.Conn, network string, address string, tls_config *tls.Config) (net.Conn, error) { /*{{{*/
+	//未指定ServerName时使用address中的host校验证书
+	if tls_config.ServerName == "" && network == "tcp" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			tls_config = tls_config.Clone()
+			tls_config.ServerName = host
+		}
+	}
+
+	tc := tls.Client(nc, tls_config)
+	if timeout := time.Duration(dialTimeout.Load()); timeout > 0 {
+		tc.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := tc.Handshake(); err != nil {
+		nc.Close()
+		return nil, &TLSError{Address: address, Err: err}
 	}
-	return newConnection(nc), nil
+	tc.SetDeadline(time.Time{})
+
+	return tc, nil
 } /*}}}*/
 
 func newConnection(c net.Conn) *Connection { /*{{{*/
@@ -56,50 +147,108 @@ func newConnection(c net.Conn) *Connection { /*{{{*/
 } /*}}}*/
 
 func (this *Connection) readResponse() (*response, error) { /*{{{*/
-	b := make([]byte, 24)
+	res := &response{}
+	if err := this.readResponseInto(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+} /*}}}*/
 
-	if readTimeout > 0 {
-		this.c.SetReadDeadline(time.Now().Add(readTimeout))
+//读取响应到res，body使用bodyBufferPool，不再使用时调用res.releaseBody()回收
+func (this *Connection) readResponseInto(res *response) error { /*{{{*/
+	b := this.header_buf[:]
+
+	if timeout := time.Duration(readTimeout.Load()); timeout > 0 {
+		this.c.SetReadDeadline(time.Now().Add(timeout))
 	}
 
-	if _, err := this.buffered.Read(b); err != nil {
+	if _, err := io.ReadFull(this.buffered.Reader, b); err != nil {
 		//if err == io.EOF {
-		return nil, ErrBadConn
+		return ErrBadConn
 		//} else {
 		//	return nil, err
 		//}
 	}
 
-	response_header := this.parseHeader(b)
+	this.parseHeader(b, &res.header)
+	if n := int(res.header.bodylen) - int(res.header.extlen) - int(res.header.keylen); n > 0 && res.header.status == STATUS_SUCCESS {
+		this.valueLen = n
+	}
 
-	if response_header.magic != MAGIC_RES {
-		return nil, errors.New("invalid magic")
+	if res.header.magic != MAGIC_RES {
+		this.log.log(LogError, "memcache: invalid response magic", "server", this.address, "magic", res.header.magic)
+		return ErrInvalMagic
 	}
 
-	res := &response{header: response_header}
+	if res.header.bodylen > 0 {
+		if timeout := time.Duration(readTimeout.Load()); timeout > 0 {
+			this.c.SetReadDeadline(time.Now().Add(timeout))
+		}
 
-	if response_header.bodylen > 0 {
-		if readTimeout > 0 {
-			this.c.SetReadDeadline(time.Now().Add(readTimeout))
+		n := int(res.header.bodylen)
+		res.bodyBuf = bodyBufferPool.Get().(*[]byte)
+		if cap(*res.bodyBuf) < n {
+			*res.bodyBuf = make([]byte, n)
 		}
+		res.bodyByte = (*res.bodyBuf)[:n]
 
-		res.bodyByte = make([]byte, response_header.bodylen)
-        _, err := io.ReadFull(this.buffered, res.bodyByte)
-        if err != nil {
-            return nil, ErrBadConn
-        }
+		if _, err := io.ReadFull(this.buffered.Reader, res.bodyByte); err != nil {
+			res.releaseBody()
+			return ErrBadConn
+		}
 	}
 
-	return res, nil
+	return nil
+} /*}}}*/
+
+//回收body缓冲区，之后不能再使用bodyByte
+func (this *response) releaseBody() { /*{{{*/
+	if this.bodyBuf == nil {
+		return
+	}
+	if cap(*this.bodyBuf) <= maxPooledBodySize {
+		bodyBufferPool.Put(this.bodyBuf)
+	}
+	this.bodyBuf = nil
+	this.bodyByte = nil
+} /*}}}*/
+
+//返回body中的value部分(去掉extras、key)
+func (this *response) value() []byte { /*{{{*/
+	return this.bodyByte[uint16(this.header.extlen)+this.header.keylen:]
 } /*}}}*/
 
 func (this *Connection) flushBufferToServer() error { /*{{{*/
-	if writeTimeout > 0 {
-		this.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if timeout := time.Duration(writeTimeout.Load()); timeout > 0 {
+		this.c.SetWriteDeadline(time.Now().Add(timeout))
 	}
 	return this.buffered.Flush()
 } /*}}}*/
 
+//写入一个请求到缓冲区，不flush
+func (this *Connection) writeRequest(opcode opcode_t, key string, extra []byte, val []byte, cas uint64, opaque uint32) error { /*{{{*/
+	header := &request_header{
+		magic:    MAGIC_REQ,
+		opcode:   opcode,
+		keylen:   uint16(len(key)),
+		extlen:   uint8(len(extra)),
+		datatype: TYPE_RAW_BYTES,
+		status:   0x00,
+		bodylen:  uint32(len(extra) + len(key) + len(val)),
+		opaque:   opaque,
+		cas:      cas,
+	}
+	if err := this.writeHeader(header); err != nil {
+		return err
+	}
+
+	this.buffered.Write(extra)
+	this.buffered.WriteString(key)
+	this.buffered.Write(val)
+
+	return nil
+} /*}}}*/
+
 func (this *Connection) get(key string, format ...interface{}) (res *response, err error) { /*{{{*/
 	header := &request_header{
 		magic:    MAGIC_REQ,
@@ -113,35 +262,264 @@ func (this *Connection) get(key string, format ...interface{}) (res *response, e
 		cas:      0x00,
 	}
 	if err := this.writeHeader(header); err != nil {
-		return nil, err
+		return nil, this.opError(OP_GET, key, 0, err)
 	}
 
 	this.buffered.WriteString(key)
 
 	if err := this.flushBufferToServer(); err != nil {
-		return nil, ErrBadConn
+		return nil, this.opError(OP_GET, key, 0, ErrBadConn)
 	}
 
 	resp, err := this.readResponse()
 	if err != nil {
-		return resp, err
+		return resp, this.opError(OP_GET, key, 0, err)
 	}
 
 	if err := this.checkResponseError(resp.header.status); err != nil {
-		return resp, err
+		resp.releaseBody()
+		return resp, this.opError(OP_GET, key, resp.header.status, err)
 	}
 
 	if resp.header.bodylen > 0 {
-		flags := binary.BigEndian.Uint32(resp.bodyByte[:resp.header.extlen])
-		res_value, err := this.formatValueFromByte(value_type_t(flags), resp.bodyByte[resp.header.extlen:], format...)
+		resp.flags = value_type_t(binary.BigEndian.Uint32(resp.bodyByte[:resp.header.extlen]))
+		if resp.flags == VALUE_TYPE_NEGATIVE {
+			resp.releaseBody()
+			return resp, this.opError(OP_GET, key, resp.header.status, ErrNotFound)
+		}
+		res_value, err := formatValueFromByte(resp.flags, resp.bodyByte[resp.header.extlen:], format...)
 		if err != nil {
 			res_value = nil
+		} else if resp.flags != VALUE_TYPE_BYTE && resp.flags != VALUE_TYPE_TAGGED {
+			//[]byte类型直接引用body，其它类型已复制，可以回收
+			resp.releaseBody()
 		}
 
 		resp.body = res_value
-		return resp, err
+		return resp, this.opError(OP_GET, key, 0, err)
 	} else {
-		return resp, errors.New("unkown error")
+		return resp, this.opError(OP_GET, key, resp.header.status, ErrUnkown)
+	}
+} /*}}}*/
+
+//读取key的原始value追加到buf[:0]，直接从连接读取，不经过body缓冲区
+func (this *Connection) getInto(key string, buf []byte) (value []byte, cas uint64, err error) { /*{{{*/
+	if err := this.writeRequest(OP_GET, key, nil, nil, 0, 0); err != nil {
+		return buf[:0], 0, this.opError(OP_GET, key, 0, err)
+	}
+	if err := this.flushBufferToServer(); err != nil {
+		return buf[:0], 0, this.opError(OP_GET, key, 0, ErrBadConn)
+	}
+
+	if timeout := time.Duration(readTimeout.Load()); timeout > 0 {
+		this.c.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	var header response_header
+	if _, err := io.ReadFull(this.buffered.Reader, this.header_buf[:]); err != nil {
+		return buf[:0], 0, this.opError(OP_GET, key, 0, ErrBadConn)
+	}
+	this.parseHeader(this.header_buf[:], &header)
+	if header.magic != MAGIC_RES {
+		this.log.log(LogError, "memcache: invalid response magic", "server", this.address, "magic", header.magic)
+		return buf[:0], 0, this.opError(OP_GET, key, 0, ErrInvalMagic)
+	}
+
+	if err := this.checkResponseError(header.status); err != nil {
+		if _, e := this.buffered.Discard(int(header.bodylen)); e != nil {
+			return buf[:0], 0, this.opError(OP_GET, key, 0, ErrBadConn)
+		}
+		return buf[:0], 0, this.opError(OP_GET, key, header.status, err)
+	}
+
+	if header.extlen < 4 || int(header.extlen) > len(this.extra_buf) {
+		return buf[:0], 0, this.opError(OP_GET, key, header.status, ErrBadConn)
+	}
+	extra := this.extra_buf[:header.extlen]
+	if _, err := io.ReadFull(this.buffered.Reader, extra); err != nil {
+		return buf[:0], 0, this.opError(OP_GET, key, 0, ErrBadConn)
+	}
+	if _, err := this.buffered.Discard(int(header.keylen)); err != nil {
+		return buf[:0], 0, this.opError(OP_GET, key, 0, ErrBadConn)
+	}
+
+	n := int(header.bodylen) - int(header.extlen) - int(header.keylen)
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	value = buf[:n]
+	this.valueLen = n
+	if _, err := io.ReadFull(this.buffered.Reader, value); err != nil {
+		return buf[:0], 0, this.opError(OP_GET, key, 0, ErrBadConn)
+	}
+
+	if value_type_t(binary.BigEndian.Uint32(extra[:4])) == VALUE_TYPE_NEGATIVE {
+		return buf[:0], 0, this.opError(OP_GET, key, header.status, ErrNotFound)
+	}
+
+	return value, header.cas, nil
+} /*}}}*/
+
+//使用GETKQ批量读取，未命中的key服务端不返回，最后以NOOP作为结束标记
+func (this *Connection) getMulti(keys []string) (res map[string]*response, err error) { /*{{{*/
+	for i, key := range keys {
+		header := &request_header{
+			magic:    MAGIC_REQ,
+			opcode:   OP_GETKQ,
+			keylen:   uint16(len(key)),
+			extlen:   0x00,
+			datatype: TYPE_RAW_BYTES,
+			status:   0x00,
+			bodylen:  uint32(len(key)),
+			opaque:   uint32(i),
+			cas:      0x00,
+		}
+		if err := this.writeHeader(header); err != nil {
+			return nil, this.opError(OP_GETKQ, "", 0, err)
+		}
+		this.buffered.WriteString(key)
+	}
+
+	noop_header := &request_header{
+		magic:    MAGIC_REQ,
+		opcode:   OP_NOOP,
+		datatype: TYPE_RAW_BYTES,
+		opaque:   uint32(len(keys)),
+	}
+	if err := this.writeHeader(noop_header); err != nil {
+		return nil, this.opError(OP_GETKQ, "", 0, err)
+	}
+
+	if err := this.flushBufferToServer(); err != nil {
+		return nil, this.opError(OP_GETKQ, "", 0, ErrBadConn)
+	}
+
+	res = make(map[string]*response, len(keys))
+	for {
+		resp, err := this.readResponse()
+		if err != nil {
+			return res, this.opError(OP_GETKQ, "", 0, err)
+		}
+		if resp.header.opcode == OP_NOOP {
+			break
+		}
+		if resp.header.status != STATUS_SUCCESS || resp.header.extlen < 4 {
+			continue
+		}
+
+		resp.flags = value_type_t(binary.BigEndian.Uint32(resp.bodyByte[:resp.header.extlen]))
+		if resp.flags == VALUE_TYPE_NEGATIVE {
+			continue
+		}
+		//map、struct等类型没有format，由调用方反序列化
+		if resp.flags != VALUE_TYPE_BIN {
+			resp.body, _ = formatValueFromByte(resp.flags, resp.value())
+		}
+
+		key := string(resp.bodyByte[resp.header.extlen : uint16(resp.header.extlen)+resp.header.keylen])
+		res[key] = resp
+	}
+
+	return res, nil
+} /*}}}*/
+
+//使用quiet命令批量写入，成功时服务端不返回，最后以NOOP作为结束标记
+//errs只包含服务端返回了错误的key，err为连接错误
+func (this *Connection) storeMulti(opcode opcode_t, items []*Item) (errs map[string]error, err error) { /*{{{*/
+	errs = make(map[string]error)
+
+	for i, item := range items {
+		val, flags := this.getItemByte(item)
+		if val == nil {
+			errs[item.Key] = this.opError(opcode, item.Key, 0, ErrInvalValue)
+			continue
+		}
+
+		header := &request_header{
+			magic:    MAGIC_REQ,
+			opcode:   opcode,
+			keylen:   uint16(len(item.Key)),
+			extlen:   0x08,
+			datatype: TYPE_RAW_BYTES,
+			status:   0x00,
+			bodylen:  uint32(len(item.Key) + 0x08 + len(val)),
+			opaque:   uint32(i),
+			cas:      item.CAS,
+		}
+		if err := this.writeHeader(header); err != nil {
+			return errs, this.opError(opcode, item.Key, 0, err)
+		}
+
+		extra_byte := this.extra_buf[:8]
+		binary.BigEndian.PutUint32(extra_byte[0:4], flags)
+		binary.BigEndian.PutUint32(extra_byte[4:8], item.Expiration)
+
+		this.buffered.Write(extra_byte)
+		this.buffered.WriteString(item.Key)
+		this.buffered.Write(val)
+	}
+
+	return errs, this.readQuietResponses(opcode, len(items), func(i int) string { return items[i].Key }, errs)
+} /*}}}*/
+
+func (this *Connection) deleteMulti(keys []string) (errs map[string]error, err error) { /*{{{*/
+	errs = make(map[string]error)
+
+	for i, key := range keys {
+		header := &request_header{
+			magic:    MAGIC_REQ,
+			opcode:   OP_DELETEQ,
+			keylen:   uint16(len(key)),
+			extlen:   0x00,
+			datatype: TYPE_RAW_BYTES,
+			status:   0x00,
+			bodylen:  uint32(len(key)),
+			opaque:   uint32(i),
+			cas:      0x00,
+		}
+		if err := this.writeHeader(header); err != nil {
+			return errs, this.opError(OP_DELETEQ, key, 0, err)
+		}
+		this.buffered.WriteString(key)
+	}
+
+	return errs, this.readQuietResponses(OP_DELETEQ, len(keys), func(i int) string { return keys[i] }, errs)
+} /*}}}*/
+
+//发送NOOP并读取quiet命令的错误响应，根据opaque找到对应的key
+func (this *Connection) readQuietResponses(opcode opcode_t, cnt int, keyOf func(i int) string, errs map[string]error) error { /*{{{*/
+	noop_header := &request_header{
+		magic:    MAGIC_REQ,
+		opcode:   OP_NOOP,
+		datatype: TYPE_RAW_BYTES,
+		opaque:   uint32(cnt),
+	}
+	if err := this.writeHeader(noop_header); err != nil {
+		return this.opError(opcode, "", 0, err)
+	}
+
+	if err := this.flushBufferToServer(); err != nil {
+		return this.opError(opcode, "", 0, ErrBadConn)
+	}
+
+	for {
+		var resp response
+		if err := this.readResponseInto(&resp); err != nil {
+			return this.opError(opcode, "", 0, err)
+		}
+		resp.releaseBody()
+
+		if resp.header.opcode == OP_NOOP {
+			return nil
+		}
+		if int(resp.header.opaque) >= cnt {
+			continue
+		}
+
+		key := keyOf(int(resp.header.opaque))
+		if err := this.checkResponseError(resp.header.status); err != nil {
+			errs[key] = this.opError(opcode, key, resp.header.status, err)
+		}
 	}
 } /*}}}*/
 
@@ -163,21 +541,22 @@ func (this *Connection) delete(key string, cas ...uint64) (res bool, err error)
 	}
 
 	if err := this.writeHeader(header); err != nil {
-		return false, err
+		return false, this.opError(OP_DELETE, key, 0, err)
 	}
-	this.buffered.Write([]byte(key))
+	this.buffered.WriteString(key)
 
 	if err := this.flushBufferToServer(); err != nil {
-		return false, ErrBadConn
+		return false, this.opError(OP_DELETE, key, 0, ErrBadConn)
 	}
 
-	resp, err := this.readResponse()
-	if err != nil {
-		return false, err
+	var resp response
+	if err := this.readResponseInto(&resp); err != nil {
+		return false, this.opError(OP_DELETE, key, 0, err)
 	}
+	resp.releaseBody()
 
 	if err := this.checkResponseError(resp.header.status); err != nil {
-		return false, err
+		return false, this.opError(OP_DELETE, key, resp.header.status, err)
 	}
 
 	return true, nil
@@ -206,29 +585,30 @@ func (this *Connection) numberic(opcode opcode_t, key string, args ...interface{
 		opaque:   0x00,
 		cas:      uint64(cas),
 	}
-	extra_byte := make([]byte, 0x14)
+	extra_byte := this.extra_buf[:0x14]
 	binary.BigEndian.PutUint64(extra_byte[0:8], uint64(delta))
 	binary.BigEndian.PutUint64(extra_byte[8:16], 0x0000000000000000 /*uint64(initial)*/)
 	binary.BigEndian.PutUint32(extra_byte[16:20], 0x00000000 /*uint32(expiration) If the expiration value is all one-bits (0xffffffff), the operation will fail with NOT_FOUND*/)
 
 	if err := this.writeHeader(header); err != nil {
-		return false, err
+		return false, this.opError(opcode, key, 0, err)
 	}
 
 	this.buffered.Write(extra_byte)
-	this.buffered.Write([]byte(key))
+	this.buffered.WriteString(key)
 
 	if err := this.flushBufferToServer(); err != nil {
-		return false, ErrBadConn
+		return false, this.opError(opcode, key, 0, ErrBadConn)
 	}
 
-	resp, err := this.readResponse()
-	if err != nil {
-		return false, err
+	var resp response
+	if err := this.readResponseInto(&resp); err != nil {
+		return false, this.opError(opcode, key, 0, err)
 	}
+	resp.releaseBody()
 
 	if err := this.checkResponseError(resp.header.status); err != nil {
-		return false, err
+		return false, this.opError(opcode, key, resp.header.status, err)
 	}
 
 	return true, nil
@@ -237,9 +617,50 @@ func (this *Connection) numberic(opcode opcode_t, key string, args ...interface{
 func (this *Connection) store(opcode opcode_t, key string, value interface{}, timeout uint32, cas uint64) (res bool, err error) { /*{{{*/
 	val, data_type := this.getValueTypeByte(value)
 	if val == nil {
-		return false, ErrInvalValue
+		return false, this.opError(opcode, key, 0, ErrInvalValue)
+	}
+
+	if _, err := this.storeBytes(opcode, key, val, uint32(data_type), timeout, cas); err != nil {
+		return false, err
+	}
+
+	return true, nil
+} /*}}}*/
+
+func (this *Connection) storeItem(opcode opcode_t, item *Item) (res_cas uint64, err error) { /*{{{*/
+	val, flags := this.getItemByte(item)
+	if val == nil {
+		return 0, this.opError(opcode, item.Key, 0, ErrInvalValue)
 	}
 
+	return this.storeBytes(opcode, item.Key, val, flags, item.Expiration, item.CAS)
+} /*}}}*/
+
+//item.Flags为0时按Value类型转换，Value为[]byte时按原样存储，其它类型转换后必须与Flags一致(GetItem返回的Item)
+//负缓存、stream、tag使用的flags为保留值，只有client内部写入的reservedValue可以使用
+func (this *Connection) getItemByte(item *Item) (val []byte, flags uint32) { /*{{{*/
+	if item.Flags == 0 {
+		val, data_type := this.getValueTypeByte(item.Value)
+		return val, uint32(data_type)
+	}
+	if isReservedFlags(item.Flags) {
+		if b, ok := item.Value.(reservedValue); ok {
+			return b, item.Flags
+		}
+		return nil, item.Flags
+	}
+	if b, ok := item.Value.([]byte); ok {
+		return b, item.Flags
+	}
+	val, data_type := this.getValueTypeByte(item.Value)
+	if uint32(data_type) != item.Flags {
+		return nil, item.Flags
+	}
+	return val, item.Flags
+} /*}}}*/
+
+//返回服务端生成的新cas
+func (this *Connection) storeBytes(opcode opcode_t, key string, val []byte, flags uint32, timeout uint32, cas uint64) (res_cas uint64, err error) { /*{{{*/
 	header := &request_header{
 		magic:    MAGIC_REQ,
 		opcode:   opcode,
@@ -253,31 +674,32 @@ func (this *Connection) store(opcode opcode_t, key string, value interface{}, ti
 	}
 
 	if err := this.writeHeader(header); err != nil {
-		return false, err
+		return 0, this.opError(opcode, key, 0, err)
 	}
 
-	extra_byte := make([]byte, 8)
-	binary.BigEndian.PutUint32(extra_byte[0:4], uint32(data_type)) //uint32 flags
-	binary.BigEndian.PutUint32(extra_byte[4:8], timeout)           //uint32 expiration
+	extra_byte := this.extra_buf[:8]
+	binary.BigEndian.PutUint32(extra_byte[0:4], flags)   //uint32 flags
+	binary.BigEndian.PutUint32(extra_byte[4:8], timeout) //uint32 expiration
 
 	this.buffered.Write(extra_byte)
-	this.buffered.Write([]byte(key))
+	this.buffered.WriteString(key)
 	this.buffered.Write(val)
 
 	if err := this.flushBufferToServer(); err != nil {
-		return false, ErrBadConn
+		return 0, this.opError(opcode, key, 0, ErrBadConn)
 	}
 
-	resp, err := this.readResponse()
-	if err != nil {
-		return false, err
+	var resp response
+	if err := this.readResponseInto(&resp); err != nil {
+		return 0, this.opError(opcode, key, 0, err)
 	}
+	resp.releaseBody()
 
 	if err := this.checkResponseError(resp.header.status); err != nil {
-		return false, err
+		return 0, this.opError(opcode, key, resp.header.status, err)
 	}
 
-	return true, nil
+	return resp.header.cas, nil
 } /*}}}*/
 
 func (this *Connection) appends(opcode opcode_t, key string, value string, cas ...uint64) (res bool, err error) { /*{{{*/
@@ -299,21 +721,23 @@ func (this *Connection) appends(opcode opcode_t, key string, value string, cas .
 	}
 
 	if err := this.writeHeader(header); err != nil {
-		return false, err
+		return false, this.opError(opcode, key, 0, err)
 	}
-	this.buffered.Write([]byte(key + value))
+	this.buffered.WriteString(key)
+	this.buffered.WriteString(value)
 
 	if err := this.flushBufferToServer(); err != nil {
-		return false, ErrBadConn
+		return false, this.opError(opcode, key, 0, ErrBadConn)
 	}
 
-	resp, err := this.readResponse()
-	if err != nil {
-		return false, err
+	var resp response
+	if err := this.readResponseInto(&resp); err != nil {
+		return false, this.opError(opcode, key, 0, err)
 	}
+	resp.releaseBody()
 
 	if err := this.checkResponseError(resp.header.status); err != nil {
-		return false, err
+		return false, this.opError(opcode, key, resp.header.status, err)
 	}
 
 	return true, nil
@@ -338,25 +762,26 @@ func (this *Connection) flush(delay ...uint32) (res bool, err error) { /*{{{*/
 	}
 
 	if err := this.writeHeader(header); err != nil {
-		return false, err
+		return false, this.opError(OP_FLUSH, "", 0, err)
 	}
 
-	extra_byte := make([]byte, 4)
+	extra_byte := this.extra_buf[:4]
 	binary.BigEndian.PutUint32(extra_byte, set_delay)
 
 	this.buffered.Write(extra_byte)
 
 	if err := this.flushBufferToServer(); err != nil {
-		return false, ErrBadConn
+		return false, this.opError(OP_FLUSH, "", 0, ErrBadConn)
 	}
 
-	resp, err := this.readResponse()
-	if err != nil {
-		return false, err
+	var resp response
+	if err := this.readResponseInto(&resp); err != nil {
+		return false, this.opError(OP_FLUSH, "", 0, err)
 	}
+	resp.releaseBody()
 
 	if err := this.checkResponseError(resp.header.status); err != nil {
-		return false, err
+		return false, this.opError(OP_FLUSH, "", resp.header.status, err)
 	}
 
 	return true, nil
@@ -376,20 +801,21 @@ func (this *Connection) noop() (res bool, err error) { /*{{{*/
 	}
 
 	if err := this.writeHeader(header); err != nil {
-		return false, err
+		return false, this.opError(OP_NOOP, "", 0, err)
 	}
 
 	if err := this.flushBufferToServer(); err != nil {
-		return false, ErrBadConn
+		return false, this.opError(OP_NOOP, "", 0, ErrBadConn)
 	}
 
-	resp, err := this.readResponse()
-	if err != nil {
-		return false, err
+	var resp response
+	if err := this.readResponseInto(&resp); err != nil {
+		return false, this.opError(OP_NOOP, "", 0, err)
 	}
+	resp.releaseBody()
 
 	if err := this.checkResponseError(resp.header.status); err != nil {
-		return false, err
+		return false, this.opError(OP_NOOP, "", resp.header.status, err)
 	}
 
 	return true, nil
@@ -409,20 +835,20 @@ func (this *Connection) version() (v string, err error) { /*{{{*/
 	}
 
 	if err := this.writeHeader(header); err != nil {
-		return "", err
+		return "", this.opError(OP_VERSION, "", 0, err)
 	}
 
 	if err := this.flushBufferToServer(); err != nil {
-		return "", ErrBadConn
+		return "", this.opError(OP_VERSION, "", 0, ErrBadConn)
 	}
 
 	resp, err := this.readResponse()
 	if err != nil {
-		return "", err
+		return "", this.opError(OP_VERSION, "", 0, err)
 	}
 
 	if err := this.checkResponseError(resp.header.status); err != nil {
-		return "", err
+		return "", this.opError(OP_VERSION, "", resp.header.status, err)
 	}
 
 	if resp.header.bodylen > 0 {
@@ -432,6 +858,22 @@ func (this *Connection) version() (v string, err error) { /*{{{*/
 	}
 } /*}}}*/
 
+//包装错误，附带命令、key、server地址、服务端返回的status
+func (this *Connection) opError(opcode opcode_t, key string, status status_t, err error) error { /*{{{*/
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*OpError); ok {
+		return err
+	}
+	return &OpError{Op: opcode, Key: key, Server: this.address, Status: status, Err: err}
+} /*}}}*/
+
+//连接断开或响应与请求不同步(magic错误)时连接不能继续使用，需要Release而不是放回连接池
+func connBroken(err error) bool { /*{{{*/
+	return errors.Is(err, ErrBadConn) || errors.Is(err, ErrInvalMagic)
+} /*}}}*/
+
 //check server returned status
 func (this *Connection) checkResponseError(status status_t) (err error) { /*{{{*/
 	switch status {
@@ -462,8 +904,8 @@ func (this *Connection) checkResponseError(status status_t) (err error) { /*{{{*
 	}
 } /*}}}*/
 
-func (this *Connection) parseHeader(b []byte) *response_header { /*{{{*/
-	return &response_header{
+func (this *Connection) parseHeader(b []byte, header *response_header) { /*{{{*/
+	*header = response_header{
 		magic:    magic_t(b[0]),
 		opcode:   opcode_t(b[1]),
 		keylen:   uint16(binary.BigEndian.Uint16(b[2:4])),
@@ -477,7 +919,8 @@ func (this *Connection) parseHeader(b []byte) *response_header { /*{{{*/
 } /*}}}*/
 
 func (this *Connection) writeHeader(header *request_header) error { /*{{{*/
-	bin_buf := make([]byte, 24)
+	bin_buf := this.header_buf[:]
+	this.valueLen = int(header.bodylen) - int(header.extlen) - int(header.keylen)
 
 	bin_buf[0] = byte(header.magic)
 	bin_buf[1] = byte(header.opcode)
@@ -504,63 +947,72 @@ func (this *Connection) writeHeader(header *request_header) error { /*{{{*/
 } /*}}}*/
 
 func (this *Connection) getValueTypeByte(value interface{}) (body_bin []byte, value_type value_type_t) { /*{{{*/
+	return valueToByte(&this.value_buf, value)
+} /*}}}*/
+
+//value转为存储的字节及类型标记，数值类型使用buf，bool、int等不需要分配内存
+func valueToByte(buf *[20]byte, value interface{}) (body_bin []byte, value_type value_type_t) { /*{{{*/
 	switch v := value.(type) {
 	case []byte:
 		value_type = VALUE_TYPE_BYTE
 		body_bin = v
 	case int: //转为字符串处理
 		value_type = VALUE_TYPE_INT
-		s := strconv.Itoa(v)
-		body_bin = []byte(s)
+		body_bin = strconv.AppendInt(buf[:0], int64(v), 10)
 	case int8:
 		value_type = VALUE_TYPE_INT8
-		body_bin = make([]byte, 1)
+		body_bin = buf[:1]
 		body_bin[0] = byte(v)
 	case int16:
 		value_type = VALUE_TYPE_INT16
-		body_bin = make([]byte, 2)
+		body_bin = buf[:2]
 		binary.LittleEndian.PutUint16(body_bin, uint16(v))
 	case int32:
 		value_type = VALUE_TYPE_INT32
-		body_bin = make([]byte, 4)
+		body_bin = buf[:4]
 		binary.LittleEndian.PutUint32(body_bin, uint32(v))
 	case int64:
 		value_type = VALUE_TYPE_INT64
-		body_bin = make([]byte, 8)
+		body_bin = buf[:8]
 		binary.LittleEndian.PutUint64(body_bin, uint64(v))
 	case uint8:
 		value_type = VALUE_TYPE_UINT8
-		body_bin = make([]byte, 1)
+		body_bin = buf[:1]
 		body_bin[0] = byte(v)
 	case uint16:
 		value_type = VALUE_TYPE_UINT16
-		body_bin = make([]byte, 2)
+		body_bin = buf[:2]
 		binary.LittleEndian.PutUint16(body_bin, v)
 	case uint32:
 		value_type = VALUE_TYPE_UINT32
-		body_bin = make([]byte, 4)
+		body_bin = buf[:4]
 		binary.LittleEndian.PutUint32(body_bin, v)
 	case uint64:
 		value_type = VALUE_TYPE_UINT64
-		body_bin = make([]byte, 8)
+		body_bin = buf[:8]
 		binary.LittleEndian.PutUint64(body_bin, v)
 	case float32:
 		value_type = VALUE_TYPE_FLOAT32
-		body_bin = Float32ToByte(v)
+		body_bin = buf[:4]
+		binary.LittleEndian.PutUint32(body_bin, math.Float32bits(v))
 	case float64:
 		value_type = VALUE_TYPE_FLOAT64
-		body_bin = Float64ToByte(v)
+		body_bin = buf[:8]
+		binary.LittleEndian.PutUint64(body_bin, math.Float64bits(v))
 	case string:
 		value_type = VALUE_TYPE_STRING
 		body_bin = []byte(v)
 	case bool:
 		value_type = VALUE_TYPE_BOOL
-		body_bin = make([]byte, 1)
+		body_bin = buf[:1]
 		if value.(bool) {
 			body_bin[0] = uint8(1)
 		} else {
 			body_bin[0] = uint8(0)
 		}
+	case negativeValue:
+		value_type = VALUE_TYPE_NEGATIVE
+		body_bin = []byte{}
 	default: //其它数据类型：map、struct等统一尝试转byte (性能不高)
 		value_type = VALUE_TYPE_BIN
 		b, err := StructToByte(value)
@@ -575,9 +1027,9 @@ func (this *Connection) getValueTypeByte(value interface{}) (body_bin []byte, va
 	return body_bin, value_type
 } /*}}}*/
 
-func (this *Connection) formatValueFromByte(value_type value_type_t, data []byte, format ...interface{}) (value interface{}, err error) { /*{{{*/
+func formatValueFromByte(value_type value_type_t, data []byte, format ...interface{}) (value interface{}, err error) { /*{{{*/
 	switch value_type {
-	case VALUE_TYPE_BYTE:
+	case VALUE_TYPE_BYTE, VALUE_TYPE_TAGGED:
 		value = data
 	case VALUE_TYPE_INT:
 		data = bytes.Trim(data, " ")
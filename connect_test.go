@@ -0,0 +1,268 @@
+package memcache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectError(t *testing.T) { /*{{{*/
+	//监听后立即关闭，得到一个拒绝连接的地址
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	_, err = connect(address, nil, nil)
+	if !errors.Is(err, ErrNotConn) || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("dial err = %v, want ErrNotConn with the dial error", err)
+	}
+
+	dial_err := errors.New("proxy unavailable")
+	dialer := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, dial_err
+	}
+	_, err = connect(address, dialer, nil)
+	if !errors.Is(err, ErrNotConn) || !strings.Contains(err.Error(), dial_err.Error()) {
+		t.Errorf("dialer err = %v, want ErrNotConn with %q", err, dial_err)
+	}
+
+	//通过命令返回时同样保留原因
+	mc, err := NewMemcache([]*Server{&Server{Address: address, InitConn: -1, Dialer: dialer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	_, _, err = mc.Get("key")
+	if !errors.Is(err, ErrNotConn) || !strings.Contains(err.Error(), dial_err.Error()) {
+		t.Errorf("Get err = %v, want ErrNotConn with %q", err, dial_err)
+	}
+} /*}}}*/
+
+func TestOpError(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+
+	_, _, err := mc.Get("missing")
+	var op_err *OpError
+	if !errors.As(err, &op_err) {
+		t.Fatalf("Get err = %T %v, want *OpError", err, err)
+	}
+	if op_err.Op != OP_GET || op_err.Key != "missing" || op_err.Server != fs[0].addr() || op_err.Status != STATUS_KEY_ENOENT {
+		t.Errorf("OpError = %+v", op_err)
+	}
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrKeyExists) {
+		t.Errorf("errors.Is(%v, ErrNotFound) = false", err)
+	}
+	if !strings.Contains(err.Error(), `"missing"`) || !strings.Contains(err.Error(), fs[0].addr()) {
+		t.Errorf("Error() = %q, want key and server", err.Error())
+	}
+
+	if _, err := mc.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = mc.Add("key", "value")
+	if !errors.As(err, &op_err) || op_err.Op != OP_ADD || op_err.Key != "key" || !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Add err = %v, want ErrKeyExists from OP_ADD", err)
+	}
+
+	//未收到响应时Status为0
+	_, err = mc.Set("bad", make(chan int))
+	if !errors.As(err, &op_err) || op_err.Status != 0 || !errors.Is(err, ErrInvalValue) {
+		t.Errorf("Set invalid value err = %v, want ErrInvalValue", err)
+	}
+} /*}}}*/
+
+//magic错误时响应的数据部分未读取，连接不能放回连接池
+func TestInvalMagicRelease(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+	f := fs[0]
+	if _, err := mc.Set("check", "ok"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{"Get", func() error { _, _, err := mc.Get("check"); return err }},
+		{"Set", func() error { _, err := mc.Set("key", "value"); return err }},
+		{"SetItem", func() error { _, err := mc.SetItem(&Item{Key: "key", Value: "value"}); return err }},
+		{"GetMultiAs", func() error { _, err := GetMultiAs[string](mc, []string{"check", "key"}); return err }},
+		{"Delete", func() error { _, err := mc.Delete("key"); return err }},
+		{"IncrementBy", func() error { _, err := mc.IncrementBy("counter", 1, 0, 0); return err }},
+		{"SetMulti", func() error { return mc.SetMulti([]*Item{{Key: "key", Value: "value"}})["key"] }},
+	}
+	for _, tt := range tests {
+		f.mu.Lock()
+		f.badMagic = 1
+		f.mu.Unlock()
+
+		//可以重复执行的命令使用新连接重试，其它命令可能返回重试的结果
+		err := tt.op()
+		if tt.name != "Delete" && tt.name != "IncrementBy" && err != nil {
+			t.Errorf("%s err = %v, want retried", tt.name, err)
+		}
+
+		//之后的命令不会读到上一个响应剩余的数据
+		if value, _, err := mc.Get("check"); err != nil || value != "ok" {
+			t.Fatalf("%s: Get after invalid magic = %v, %v", tt.name, value, err)
+		}
+	}
+} /*}}}*/
+
+//自签名证书，包含127.0.0.1及cache.local，roots为只包含该证书的CertPool
+func newTestCert(t *testing.T) (cert tls.Certificate, roots *x509.CertPool) { /*{{{*/
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "memcache test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"cache.local"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots = x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+} /*}}}*/
+
+func newTLSFake(t *testing.T, cert tls.Certificate) *fakeServer { /*{{{*/
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFakeListener(t, tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}}))
+} /*}}}*/
+
+func TestTLSHandshake(t *testing.T) { /*{{{*/
+	cert, roots := newTestCert(t)
+	f := newTLSFake(t, cert)
+
+	//未指定ServerName时使用address中的host校验证书
+	mc, err := NewMemcache([]*Server{{Address: f.addr(), InitConn: 1, TLSConfig: &tls.Config{RootCAs: roots}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	if _, err := mc.Set("key", "value"); err != nil {
+		t.Fatalf("Set over TLS: %v", err)
+	}
+	if value, _, err := mc.Get("key"); err != nil || value != "value" {
+		t.Fatalf("Get over TLS = %v, %v", value, err)
+	}
+
+	//指定ServerName时按ServerName校验，不修改调用方的TLSConfig
+	tls_config := &tls.Config{RootCAs: roots, ServerName: "cache.local"}
+	conn, err := connect(f.addr(), nil, tls_config)
+	if err != nil {
+		t.Fatalf("connect with ServerName: %v", err)
+	}
+	conn.Close()
+	tls_config = &tls.Config{RootCAs: roots}
+	if conn, err = connect(f.addr(), nil, tls_config); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	conn.Close()
+	if tls_config.ServerName != "" {
+		t.Errorf("TLSConfig.ServerName modified to %q", tls_config.ServerName)
+	}
+
+	//Dialer返回的连接上同样进行握手
+	var dialed int
+	dialer := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed++
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	if conn, err = connect(f.addr(), dialer, &tls.Config{RootCAs: roots}); err != nil || dialed != 1 {
+		t.Fatalf("connect with Dialer = %v, dialed %d", err, dialed)
+	}
+	if v, err := conn.version(); err != nil {
+		t.Errorf("version over TLS with Dialer = %q, %v", v, err)
+	}
+	conn.Close()
+} /*}}}*/
+
+func TestTLSError(t *testing.T) { /*{{{*/
+	cert, roots := newTestCert(t)
+	f := newTLSFake(t, cert)
+
+	tests := []struct {
+		name   string
+		config *tls.Config
+		check  func(err error) bool
+	}{
+		{"unknown authority", &tls.Config{}, func(err error) bool {
+			var ua x509.UnknownAuthorityError
+			return errors.As(err, &ua)
+		}},
+		{"hostname mismatch", &tls.Config{RootCAs: roots, ServerName: "other.local"}, func(err error) bool {
+			var he x509.HostnameError
+			return errors.As(err, &he)
+		}},
+	}
+	for _, tt := range tests {
+		_, err := connect(f.addr(), nil, tt.config)
+		var tls_err *TLSError
+		if !errors.As(err, &tls_err) || tls_err.Address != f.addr() || !errors.Is(err, ErrTLS) || errors.Is(err, ErrNotConn) {
+			t.Errorf("%s: err = %v, want *TLSError", tt.name, err)
+			continue
+		}
+		if !tt.check(err) {
+			t.Errorf("%s: err = %v does not unwrap to the x509 error", tt.name, err)
+		}
+		if !strings.Contains(err.Error(), ErrTLS.Error()) || !strings.Contains(err.Error(), f.addr()) {
+			t.Errorf("%s: Error() = %q", tt.name, err.Error())
+		}
+	}
+
+	//通过命令返回时仍可以判断
+	mc, err := NewMemcache([]*Server{{Address: f.addr(), InitConn: -1, TLSConfig: &tls.Config{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	_, _, err = mc.Get("key")
+	var op_err *OpError
+	if !errors.Is(err, ErrTLS) || !errors.As(err, &op_err) || op_err.Server != f.addr() {
+		t.Errorf("Get err = %v, want ErrTLS", err)
+	}
+
+	//server不响应握手时按dialTimeout超时
+	addr, _ := newSilentServer(t)
+	timeout := dialTimeout.Load()
+	dialTimeout.Store(int64(time.Millisecond * 50))
+	defer dialTimeout.Store(timeout)
+	_, err = connect(addr, nil, &tls.Config{RootCAs: roots})
+	var net_err net.Error
+	if !errors.Is(err, ErrTLS) || !errors.As(err, &net_err) || !net_err.Timeout() {
+		t.Errorf("silent server err = %v, want ErrTLS with timeout", err)
+	}
+} /*}}}*/
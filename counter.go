@@ -0,0 +1,103 @@
+package memcache
+
+import (
+	"encoding/binary"
+)
+
+//counter的expire为该值时key不存在不创建，返回ErrNotFound
+const CounterNoCreate uint32 = 0xffffffff
+
+//key加delta并返回新值，key不存在时写入initial并返回initial，expire只在写入initial时生效
+//key存储的value需要是Set写入的int或IncrementBy创建的数值
+func (this *Memcache) IncrementBy(key string, delta uint64, initial uint64, expire uint32) (value uint64, err error) { /*{{{*/
+	return this.counter(OP_INCREMENT, key, delta, initial, expire)
+} /*}}}*/
+
+//key减delta并返回新值，结果最小为0，key不存在时写入initial并返回initial
+func (this *Memcache) DecrementBy(key string, delta uint64, initial uint64, expire uint32) (value uint64, err error) { /*{{{*/
+	return this.counter(OP_DECREMENT, key, delta, initial, expire)
+} /*}}}*/
+
+func (this *Memcache) counter(opcode opcode_t, key string, delta uint64, initial uint64, expire uint32) (value uint64, err error) { /*{{{*/
+	key, err = this.storageKey(opcode, key)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkExpire(expire); err != nil {
+		return 0, newOpError(opcode, key, nil, err)
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
+	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, opcode, key, server)
+	defer this.finish(&call, &err)
+	if server == nil {
+		return 0, newOpError(opcode, key, nil, ErrNotConn)
+	}
+
+	for ; call.tries < badTryCnt; call.tries++ {
+		conn, e := server.pool.Get()
+		if e != nil {
+			this.sendBadServerNotice()
+			return 0, newOpError(opcode, key, server, e)
+		}
+
+		value, err = conn.counter(opcode, key, delta, initial, expire)
+		call.size = conn.valueLen
+
+		if connBroken(err) {
+			server.pool.Release(conn)
+		} else {
+			server.pool.Put(conn)
+			break
+		}
+	}
+
+	return value, err
+} /*}}}*/
+
+func (this *Connection) counter(opcode opcode_t, key string, delta uint64, initial uint64, expire uint32) (value uint64, err error) { /*{{{*/
+	header := &request_header{
+		magic:    MAGIC_REQ,
+		opcode:   opcode,
+		keylen:   uint16(len(key)),
+		extlen:   0x14,
+		datatype: TYPE_RAW_BYTES,
+		status:   0x00,
+		bodylen:  uint32(len(key) + 0x14),
+		opaque:   0x00,
+		cas:      0x00,
+	}
+	extra_byte := this.extra_buf[:0x14]
+	binary.BigEndian.PutUint64(extra_byte[0:8], delta)
+	binary.BigEndian.PutUint64(extra_byte[8:16], initial)
+	binary.BigEndian.PutUint32(extra_byte[16:20], expire)
+
+	if err := this.writeHeader(header); err != nil {
+		return 0, this.opError(opcode, key, 0, err)
+	}
+
+	this.buffered.Write(extra_byte)
+	this.buffered.WriteString(key)
+
+	if err := this.flushBufferToServer(); err != nil {
+		return 0, this.opError(opcode, key, 0, ErrBadConn)
+	}
+
+	var resp response
+	if err := this.readResponseInto(&resp); err != nil {
+		return 0, this.opError(opcode, key, 0, err)
+	}
+	defer resp.releaseBody()
+
+	if err := this.checkResponseError(resp.header.status); err != nil {
+		return 0, this.opError(opcode, key, resp.header.status, err)
+	}
+
+	//响应的value为8字节的新值
+	if b := resp.value(); len(b) == 8 {
+		return binary.BigEndian.Uint64(b), nil
+	}
+	return 0, this.opError(opcode, key, resp.header.status, ErrUnkown)
+} /*}}}*/
@@ -0,0 +1,193 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DNS解析，*net.Resolver实现了该接口，测试时可以替换
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+type DiscoveryOptions struct {
+	Name     string        //域名
+	Service  string        //非空时查询SRV记录_service._proto.name，权重使用SRV的weight
+	Proto    string        //SRV的协议，默认tcp
+	Port     int           //查询A/AAAA记录时server的端口
+	Resolver Resolver      //默认net.DefaultResolver
+	Interval time.Duration //刷新间隔，默认30s，小于0时不刷新
+	Timeout  time.Duration //每次解析的超时时间，默认5s
+
+	//发现的server使用Template的MaxConn、InitConn、IdleTime、TLSConfig、Dialer，Weight为A/AAAA记录的权重
+	Template *Server
+}
+
+var (
+	defaultDiscoveryInterval = time.Second * 30
+	defaultDiscoveryTimeout  = time.Second * 5
+)
+
+//通过DNS发现server并创建Memcache，之后按Interval定时解析，记录变化时更新server列表
+//解析失败或结果为空时保留当前的server列表
+func NewMemcacheFromDNS(opts *DiscoveryOptions) (mem *Memcache, err error) { /*{{{*/
+	if opts == nil || opts.Name == "" || (opts.Service == "" && opts.Port <= 0) {
+		return nil, ErrInval
+	}
+
+	server_list, err := opts.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err = NewMemcache(server_list)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := opts.Interval
+	if interval == 0 {
+		interval = defaultDiscoveryInterval
+	}
+	if interval > 0 {
+		go mem.refreshServers(interval, opts.lookup, nil)
+	}
+
+	return mem, nil
+} /*}}}*/
+
+//定时获取server列表并更新，Close后停止
+//applied不为nil时在server列表更新成功(或与当前一致)后调用
+func (this *Memcache) refreshServers(interval time.Duration, lookup func() ([]*Server, error), applied func()) { /*{{{*/
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.manager.stopRefresh:
+			return
+		case <-ticker.C:
+		}
+
+		server_list, err := lookup()
+		if err != nil {
+			this.log.log(LogWarn, "memcache: refresh server list failed", "err", err)
+			continue
+		}
+		if len(server_list) == 0 {
+			continue
+		}
+		if !this.sameServers(server_list) {
+			if err := this.SetServers(server_list); err != nil {
+				this.log.log(LogWarn, "memcache: refresh server list failed", "err", err)
+				continue
+			}
+		}
+		if applied != nil {
+			applied()
+		}
+	}
+} /*}}}*/
+
+//server列表的address、weight及连接池配置与当前一致时不需要更新
+func (this *Memcache) sameServers(server_list []*Server) bool { /*{{{*/
+	this.manager.Lock()
+	defer this.manager.Unlock()
+
+	if len(server_list) != len(this.manager.serverList) {
+		return false
+	}
+	current := make(map[string]*Server, len(server_list))
+	for _, s := range this.manager.serverList {
+		current[s.Address] = s
+	}
+	for _, s := range server_list {
+		c, ok := current[s.Address]
+		if !ok || (c.Weight != s.Weight && !(c.Weight == 1 && s.Weight == 0)) || !samePoolConfig(c, s) {
+			return false
+		}
+	}
+	return true
+} /*}}}*/
+
+func (this *DiscoveryOptions) lookup() ([]*Server, error) { /*{{{*/
+	resolver := this.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	timeout := this.Timeout
+	if timeout <= 0 {
+		timeout = defaultDiscoveryTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var server_list []*Server
+
+	if this.Service != "" {
+		proto := this.Proto
+		if proto == "" {
+			proto = "tcp"
+		}
+		_, records, err := resolver.LookupSRV(ctx, this.Service, proto, this.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		//只使用priority最小(优先级最高)的一组记录
+		var priority uint16
+		for i, r := range records {
+			if i == 0 || r.Priority < priority {
+				priority = r.Priority
+			}
+		}
+		for _, r := range records {
+			if r.Priority != priority {
+				continue
+			}
+			address := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+			server_list = append(server_list, this.newServer(address, int(r.Weight)))
+		}
+	} else {
+		hosts, err := resolver.LookupHost(ctx, this.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		weight := 0
+		if this.Template != nil {
+			weight = this.Template.Weight
+		}
+		for _, host := range hosts {
+			address := net.JoinHostPort(host, strconv.Itoa(this.Port))
+			server_list = append(server_list, this.newServer(address, weight))
+		}
+	}
+
+	if len(server_list) == 0 {
+		return nil, errors.New("No server found: " + this.Name)
+	}
+
+	sort.Slice(server_list, func(i, j int) bool {
+		return server_list[i].Address < server_list[j].Address
+	})
+	return server_list, nil
+} /*}}}*/
+
+func (this *DiscoveryOptions) newServer(address string, weight int) *Server { /*{{{*/
+	s := &Server{Address: address, Weight: weight}
+	if this.Template != nil {
+		s.MaxConn = this.Template.MaxConn
+		s.InitConn = this.Template.InitConn
+		s.IdleTime = this.Template.IdleTime
+		s.TLSConfig = this.Template.TLSConfig
+		s.Dialer = this.Template.Dialer
+	}
+	return s
+} /*}}}*/
@@ -0,0 +1,259 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//返回预先设置的记录，测试时不访问DNS
+type stubResolver struct {
+	mu    sync.Mutex
+	srv   []*net.SRV
+	hosts []string
+	err   error
+	calls int
+}
+
+func (this *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.calls++
+	if this.err != nil {
+		return "", nil, this.err
+	}
+	return "", this.srv, nil
+} /*}}}*/
+
+func (this *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.calls++
+	if this.err != nil {
+		return nil, this.err
+	}
+	return this.hosts, nil
+} /*}}}*/
+
+func (this *stubResolver) set(srv []*net.SRV, err error) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.srv = srv
+	this.err = err
+} /*}}}*/
+
+func (this *stubResolver) callCount() int { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.calls
+} /*}}}*/
+
+func srvRecord(t *testing.T, f *fakeServer, priority, weight uint16) *net.SRV { /*{{{*/
+	host, port, err := net.SplitHostPort(f.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(port)
+	return &net.SRV{Target: host + ".", Port: uint16(n), Priority: priority, Weight: weight}
+} /*}}}*/
+
+func serverAddrs(mc *Memcache) string { /*{{{*/
+	mc.manager.Lock()
+	defer mc.manager.Unlock()
+	var addrs []string
+	for _, s := range mc.manager.serverList {
+		addrs = append(addrs, s.Address)
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+} /*}}}*/
+
+func joinAddrs(fs ...*fakeServer) string { /*{{{*/
+	var addrs []string
+	for _, f := range fs {
+		addrs = append(addrs, f.addr())
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+} /*}}}*/
+
+//等待定时刷新把server列表更新为want
+func waitServers(t *testing.T, mc *Memcache, want string) { /*{{{*/
+	deadline := time.Now().Add(time.Second * 2)
+	for serverAddrs(mc) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("servers = %s, want %s", serverAddrs(mc), want)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+} /*}}}*/
+
+func TestSameServers(t *testing.T) { /*{{{*/
+	server := func(address string, weight int) *Server {
+		return &Server{Address: address, Weight: weight, InitConn: -1}
+	}
+	mc, err := NewMemcache([]*Server{server("10.0.0.1:11211", 0), server("10.0.0.2:11211", 5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+
+	tests := []struct {
+		name string
+		list []*Server
+		want bool
+	}{
+		{"same", []*Server{server("10.0.0.1:11211", 1), server("10.0.0.2:11211", 5)}, true},
+		{"order", []*Server{server("10.0.0.2:11211", 5), server("10.0.0.1:11211", 1)}, true},
+		{"default weight", []*Server{server("10.0.0.1:11211", 0), server("10.0.0.2:11211", 5)}, true},
+		{"weight changed", []*Server{server("10.0.0.1:11211", 0), server("10.0.0.2:11211", 6)}, false},
+		{"pool changed", []*Server{server("10.0.0.1:11211", 0), &Server{Address: "10.0.0.2:11211", Weight: 5, InitConn: -1, MaxConn: 4}}, false},
+		{"address changed", []*Server{server("10.0.0.1:11211", 0), server("10.0.0.3:11211", 5)}, false},
+		{"added", []*Server{server("10.0.0.1:11211", 0), server("10.0.0.2:11211", 5), server("10.0.0.3:11211", 0)}, false},
+		{"removed", []*Server{server("10.0.0.1:11211", 0)}, false},
+	}
+	for _, tt := range tests {
+		if got := mc.sameServers(tt.list); got != tt.want {
+			t.Errorf("%s: sameServers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+} /*}}}*/
+
+func TestDiscoveryLookup(t *testing.T) { /*{{{*/
+	r := &stubResolver{
+		srv: []*net.SRV{
+			{Target: "b.local.", Port: 11211, Priority: 1, Weight: 20},
+			{Target: "a.local.", Port: 11212, Priority: 1, Weight: 10},
+			{Target: "c.local.", Port: 11211, Priority: 5, Weight: 10},
+		},
+		hosts: []string{"10.0.0.2", "10.0.0.1"},
+	}
+
+	//SRV只使用priority最小的一组，按地址排序
+	opts := &DiscoveryOptions{Name: "mc.local", Service: "memcache", Resolver: r, Template: &Server{MaxConn: 8}}
+	list, err := opts.lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Address != "a.local:11212" || list[0].Weight != 10 || list[1].Address != "b.local:11211" || list[1].Weight != 20 {
+		t.Fatalf("SRV lookup = %v", list)
+	}
+	if list[0].MaxConn != 8 {
+		t.Fatalf("MaxConn = %d, want template value 8", list[0].MaxConn)
+	}
+
+	opts = &DiscoveryOptions{Name: "mc.local", Port: 11211, Resolver: r}
+	list, err = opts.lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Address != "10.0.0.1:11211" || list[1].Address != "10.0.0.2:11211" {
+		t.Fatalf("host lookup = %v", list)
+	}
+
+	r.set(nil, nil)
+	r.hosts = nil
+	if _, err := opts.lookup(); err == nil {
+		t.Fatal("empty lookup should fail")
+	}
+} /*}}}*/
+
+func TestDiscoveryResolverError(t *testing.T) { /*{{{*/
+	lookup_err := errors.New("lookup failed")
+
+	//启动时解析失败直接返回错误
+	r := &stubResolver{err: lookup_err}
+	if _, err := NewMemcacheFromDNS(&DiscoveryOptions{Name: "mc.local", Service: "memcache", Resolver: r}); !errors.Is(err, lookup_err) {
+		t.Fatalf("NewMemcacheFromDNS err = %v, want %v", err, lookup_err)
+	}
+
+	//刷新时解析失败或结果为空保留当前列表
+	f := newFake(t)
+	r = &stubResolver{srv: []*net.SRV{srvRecord(t, f, 1, 0)}}
+	mc, err := NewMemcacheFromDNS(&DiscoveryOptions{Name: "mc.local", Service: "memcache", Resolver: r, Interval: time.Millisecond * 10, Template: &Server{InitConn: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+
+	r.set(nil, lookup_err)
+	calls := r.callCount()
+	for r.callCount() < calls+3 {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if got := serverAddrs(mc); got != f.addr() {
+		t.Fatalf("servers = %s after resolver error, want %s", got, f.addr())
+	}
+
+	r.set([]*net.SRV{}, nil)
+	calls = r.callCount()
+	for r.callCount() < calls+3 {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if got := serverAddrs(mc); got != f.addr() {
+		t.Fatalf("servers = %s after empty lookup, want %s", got, f.addr())
+	}
+	if _, err := mc.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+} /*}}}*/
+
+func TestDiscoveryRefresh(t *testing.T) { /*{{{*/
+	var fs []*fakeServer
+	for i := 0; i < 3; i++ {
+		fs = append(fs, newFake(t))
+	}
+
+	r := &stubResolver{srv: []*net.SRV{srvRecord(t, fs[0], 1, 0)}}
+	mc, err := NewMemcacheFromDNS(&DiscoveryOptions{Name: "mc.local", Service: "memcache", Resolver: r, Interval: time.Millisecond * 10, Template: &Server{InitConn: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	if got := serverAddrs(mc); got != joinAddrs(fs[0]) {
+		t.Fatalf("servers = %s, want %s", got, joinAddrs(fs[0]))
+	}
+
+	//新增节点
+	r.set([]*net.SRV{srvRecord(t, fs[0], 1, 0), srvRecord(t, fs[1], 1, 0), srvRecord(t, fs[2], 1, 0)}, nil)
+	waitServers(t, mc, joinAddrs(fs...))
+
+	for i := 0; i < 100; i++ {
+		if _, err := mc.Set("key_"+strconv.Itoa(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, f := range fs {
+		f.mu.Lock()
+		ops := f.ops
+		f.mu.Unlock()
+		if ops == 0 {
+			t.Errorf("server %d received no request after it was added", i)
+		}
+	}
+
+	//删除节点，之后的请求只发送到剩余的server
+	r.set([]*net.SRV{srvRecord(t, fs[1], 1, 0)}, nil)
+	waitServers(t, mc, joinAddrs(fs[1]))
+
+	fs[0].mu.Lock()
+	before := fs[0].ops
+	fs[0].mu.Unlock()
+	for i := 0; i < 100; i++ {
+		if _, _, err := mc.Get("key_" + strconv.Itoa(i)); err != nil && !errors.Is(err, ErrNotFound) {
+			t.Fatal(err)
+		}
+	}
+	fs[0].mu.Lock()
+	after := fs[0].ops
+	fs[0].mu.Unlock()
+	if after != before {
+		t.Errorf("removed server received %d requests", after-before)
+	}
+} /*}}}*/
@@ -1,11 +1,20 @@
 package memcache
 
-import "errors"
+import (
+	"errors"
+	"strconv"
+)
 
 //connection error
 var (
-	ErrBadConn = errors.New("Connect closed")
-	ErrNotConn = errors.New("Can't connect to server")
+	ErrBadConn    = errors.New("Connect closed")
+	ErrNotConn    = errors.New("Can't connect to server")
+	ErrInvalMagic = errors.New("Invalid magic")
+	ErrTLS        = errors.New("TLS handshake failed")
+
+	ErrInvalAddress  = errors.New("Invalid server address")
+	ErrClusterConfig = errors.New("Invalid cluster config response")
+	ErrInvalConfig   = errors.New("Invalid config file")
 )
 
 //memcached server returned error
@@ -24,7 +33,72 @@ var (
 )
 
 var (
-	ErrInvalValue  = errors.New("Unkown value type")
-	ErrInvalFormat = errors.New("Invalid format struct")
-	ErrNoFormat    = errors.New("Format struct empty")
+	ErrInvalValue   = errors.New("Unkown value type")
+	ErrInvalFormat  = errors.New("Invalid format struct")
+	ErrNoFormat     = errors.New("Format struct empty")
+	ErrTypeMismatch = errors.New("Value type mismatch")
+	ErrInvalExpire  = errors.New("Invalid expiration")
+	ErrStreamBroken = errors.New("Stream chunk missing or corrupt")
+	ErrLockNotHeld  = errors.New("Lock not held")
+	ErrMalformedKey = errors.New("Malformed key: empty, longer than 250 bytes or contains control characters or spaces")
 )
+
+//GetAs/GetMultiAs存储的value类型与期望类型不一致
+type TypeError struct {
+	Key    string
+	Stored string //存储时的value类型
+	Want   string //期望的value类型
+}
+
+func (this *TypeError) Error() string {
+	return ErrTypeMismatch.Error() + ": key " + strconv.Quote(this.Key) + " stored as " + this.Stored + ", want " + this.Want
+}
+
+func (this *TypeError) Unwrap() error {
+	return ErrTypeMismatch
+}
+
+//TLS握手失败，连接已建立但证书校验、协商失败，与ErrNotConn区分
+type TLSError struct {
+	Address string
+	Err     error //crypto/tls返回的错误，如x509.UnknownAuthorityError
+}
+
+func (this *TLSError) Error() string {
+	return ErrTLS.Error() + " with " + this.Address + ": " + this.Err.Error()
+}
+
+func (this *TLSError) Is(target error) bool {
+	return target == ErrTLS
+}
+
+func (this *TLSError) Unwrap() error {
+	return this.Err
+}
+
+//命令执行失败时返回的错误，可以用errors.Is(err, memcache.ErrNotFound)判断具体错误
+type OpError struct {
+	Op     opcode_t //命令
+	Key    string
+	Server string   //server地址
+	Status status_t //服务端返回的status，未收到响应时为0
+	Err    error    //具体错误
+}
+
+func (this *OpError) Error() string {
+	s := this.Op.String()
+	if this.Key != "" {
+		s += " " + strconv.Quote(this.Key)
+	}
+	if this.Server != "" {
+		s += " on " + this.Server
+	}
+	if this.Status != STATUS_SUCCESS {
+		s += " (status 0x" + strconv.FormatUint(uint64(this.Status), 16) + ")"
+	}
+	return s + ": " + this.Err.Error()
+}
+
+func (this *OpError) Unwrap() error {
+	return this.Err
+}
@@ -0,0 +1,64 @@
+package memcache
+
+import (
+	"math"
+	"time"
+)
+
+//memcached过期时间超过30天时按unix时间戳处理
+const maxRelativeExpire = 60 * 60 * 24 * 30
+
+//将相对过期时间转为memcached的expiration，超过30天时转为unix时间戳
+//d为0表示永不过期，不足1秒按1秒处理，小于0返回ErrInvalExpire
+//
+//	exp, err := memcache.ExpireIn(time.Hour * 24 * 31)
+//	mc.Set("test_key", value, exp)
+func ExpireIn(d time.Duration) (uint32, error) { /*{{{*/
+	if d < 0 {
+		return 0, ErrInvalExpire
+	}
+	if d == 0 {
+		return 0, nil
+	}
+
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds <= maxRelativeExpire {
+		return uint32(seconds), nil
+	}
+
+	return unixExpire(time.Now().Unix() + seconds)
+} /*}}}*/
+
+//将过期时间点转为memcached的expiration，30天以内转为相对时间(避免client与server时钟不一致)
+//t为零值表示永不过期，已经过去的时间返回ErrInvalExpire
+func ExpireAt(t time.Time) (uint32, error) { /*{{{*/
+	if t.IsZero() {
+		return 0, nil
+	}
+
+	d := time.Until(t)
+	if d <= 0 {
+		return 0, ErrInvalExpire
+	}
+	if d <= maxRelativeExpire*time.Second {
+		return ExpireIn(d)
+	}
+
+	return unixExpire(t.Unix())
+} /*}}}*/
+
+//检查写入命令的expiration，所有写入操作在发送前调用
+//超过30天的值按unix时间戳处理，已经过去的时间会使数据写入后立即过期(如误传入的秒数)，返回ErrInvalExpire
+func checkExpire(expire uint32) error { /*{{{*/
+	if expire > maxRelativeExpire && int64(expire) <= time.Now().Unix() {
+		return ErrInvalExpire
+	}
+	return nil
+} /*}}}*/
+
+func unixExpire(ts int64) (uint32, error) { /*{{{*/
+	if ts > math.MaxUint32 {
+		return 0, ErrInvalExpire
+	}
+	return uint32(ts), nil
+} /*}}}*/
@@ -0,0 +1,78 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckExpire(t *testing.T) { /*{{{*/
+	now := uint32(time.Now().Unix())
+	tests := []struct {
+		expire uint32
+		valid  bool
+	}{
+		{0, true},
+		{60, true},
+		{maxRelativeExpire, true},
+		{maxRelativeExpire + 1, false}, //1970年
+		{now - 10, false},
+		{now + 3600, true},
+		{CounterNoCreate, true},
+	}
+	for _, tt := range tests {
+		if err := checkExpire(tt.expire); (err == nil) != tt.valid {
+			t.Errorf("checkExpire(%d) = %v, want valid %v", tt.expire, err, tt.valid)
+		}
+	}
+} /*}}}*/
+
+//所有写入操作都检查expiration，不发送到服务端
+func TestStoreInvalExpire(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+	expire := uint32(maxRelativeExpire + 3600)
+
+	check := func(name string, err error) {
+		t.Helper()
+		if !errors.Is(err, ErrInvalExpire) {
+			t.Errorf("%s: err = %v, want ErrInvalExpire", name, err)
+		}
+	}
+	_, err := mc.Set("key", "value", expire)
+	check("Set", err)
+	_, err = mc.Add("key", "value", expire)
+	check("Add", err)
+	_, err = mc.Replace("key", "value", uint64(expire))
+	check("Replace", err)
+	_, err = mc.SetItem(&Item{Key: "key", Value: "value", Expiration: expire})
+	check("SetItem", err)
+	_, err = mc.AddItem(&Item{Key: "key", Value: "value", Expiration: expire})
+	check("AddItem", err)
+	errs := mc.SetMulti([]*Item{{Key: "key", Value: "value", Expiration: expire}})
+	check("SetMulti", errs["key"])
+	_, _, err = mc.SetAsync("key", "value", expire).Wait(context.Background())
+	check("SetAsync", err)
+	_, err = mc.IncrementBy("counter", 1, 1, expire)
+	check("IncrementBy", err)
+
+	mc.SetBatching(time.Millisecond, 16)
+	_, err = mc.Set("key", "value", expire)
+	check("Set with batching", err)
+
+	fs[0].mu.Lock()
+	ops := fs[0].ops
+	fs[0].mu.Unlock()
+	if ops != 0 {
+		t.Errorf("server received %d requests", ops)
+	}
+
+	//合法的unix时间戳可以写入
+	exp, err := ExpireAt(time.Now().Add(time.Hour * 24 * 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.Set("key", "value", exp); err != nil {
+		t.Fatal(err)
+	}
+} /*}}}*/
@@ -0,0 +1,296 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//测试使用的binary协议server，数据保存在内存中，支持get/set/add/replace/delete/incr/decr/append/prepend及其quiet命令
+
+type fakeItem struct {
+	flags uint32
+	val   []byte
+	cas   uint64
+	exp   time.Time
+}
+
+type fakeServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	items map[string]*fakeItem
+	cas   uint64
+	ops   int //收到的请求数
+	conns map[net.Conn]bool
+
+	dropNoop  int //之后n次NOOP不返回响应并关闭连接，模拟批量命令已执行但连接中断
+	dropReply int //之后n个请求执行后不返回响应并关闭连接，模拟命令已执行但连接中断
+	badMagic  int //之后n个响应的magic错误，连接不关闭，模拟响应与请求不同步
+}
+
+func newFake(t testing.TB) *fakeServer { /*{{{*/
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFakeListener(t, ln)
+} /*}}}*/
+
+//使用指定的listener，如tls.NewListener
+func newFakeListener(t testing.TB, ln net.Listener) *fakeServer { /*{{{*/
+	f := &fakeServer{ln: ln, items: make(map[string]*fakeItem), conns: make(map[net.Conn]bool)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns[c] = true
+			f.mu.Unlock()
+			go f.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+} /*}}}*/
+
+//创建n个fakeServer及使用它们的Memcache
+func newFakeClient(t testing.TB, n int) (*Memcache, []*fakeServer) { /*{{{*/
+	var fs []*fakeServer
+	var list []*Server
+	for i := 0; i < n; i++ {
+		f := newFake(t)
+		fs = append(fs, f)
+		list = append(list, &Server{Address: f.addr(), InitConn: 1})
+	}
+	mc, err := NewMemcache(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mc.Close)
+	return mc, fs
+} /*}}}*/
+
+func (this *fakeServer) addr() string { /*{{{*/
+	return this.ln.Addr().String()
+} /*}}}*/
+
+//关闭所有已建立的连接，模拟server重启
+func (this *fakeServer) dropConns() { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for c := range this.conns {
+		c.Close()
+		delete(this.conns, c)
+	}
+} /*}}}*/
+
+//使key立即过期，模拟ttl到期
+func (this *fakeServer) expire(key string) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if item := this.items[key]; item != nil {
+		item.exp = time.Now().Add(-time.Second)
+	}
+} /*}}}*/
+
+func (this *fakeServer) serve(c net.Conn) { /*{{{*/
+	defer c.Close()
+	hdr := make([]byte, 24)
+	for {
+		if _, err := io.ReadFull(c, hdr); err != nil {
+			return
+		}
+		if magic_t(hdr[0]) != MAGIC_REQ {
+			return
+		}
+		op := opcode_t(hdr[1])
+		keylen := int(binary.BigEndian.Uint16(hdr[2:4]))
+		extlen := int(hdr[4])
+		bodylen := int(binary.BigEndian.Uint32(hdr[8:12]))
+		opaque := binary.BigEndian.Uint32(hdr[12:16])
+		cas := binary.BigEndian.Uint64(hdr[16:24])
+		body := make([]byte, bodylen)
+		if _, err := io.ReadFull(c, body); err != nil {
+			return
+		}
+		ext := body[:extlen]
+		key := string(body[extlen : extlen+keylen])
+		val := body[extlen+keylen:]
+
+		this.mu.Lock()
+		this.ops++
+		status, rext, rval, rcas := this.handle(op, ext, key, val, cas)
+		drop := false
+		if this.dropReply > 0 {
+			this.dropReply--
+			drop = true
+		} else if op == OP_NOOP && this.dropNoop > 0 {
+			this.dropNoop--
+			drop = true
+		}
+		this.mu.Unlock()
+		if drop {
+			return
+		}
+
+		//quiet命令成功时不返回，GETQ/GETKQ未命中时不返回
+		switch op {
+		case OP_GETQ, OP_GETKQ:
+			if status == STATUS_KEY_ENOENT {
+				continue
+			}
+		case OP_SETQ, OP_ADDQ, OP_REPLACEQ, OP_DELETEQ:
+			if status == STATUS_SUCCESS {
+				continue
+			}
+		}
+		rkey := ""
+		if op == OP_GETK || op == OP_GETKQ {
+			rkey = key
+		}
+
+		resp := make([]byte, 24+len(rext)+len(rkey)+len(rval))
+		resp[0] = byte(MAGIC_RES)
+		this.mu.Lock()
+		if this.badMagic > 0 {
+			this.badMagic--
+			resp[0] = byte(MAGIC_REQ)
+		}
+		this.mu.Unlock()
+		resp[1] = byte(op)
+		binary.BigEndian.PutUint16(resp[2:4], uint16(len(rkey)))
+		resp[4] = byte(len(rext))
+		binary.BigEndian.PutUint16(resp[6:8], uint16(status))
+		binary.BigEndian.PutUint32(resp[8:12], uint32(len(rext)+len(rkey)+len(rval)))
+		binary.BigEndian.PutUint32(resp[12:16], opaque)
+		binary.BigEndian.PutUint64(resp[16:24], rcas)
+		copy(resp[24:], rext)
+		copy(resp[24+len(rext):], rkey)
+		copy(resp[24+len(rext)+len(rkey):], rval)
+		if _, err := c.Write(resp); err != nil {
+			return
+		}
+	}
+} /*}}}*/
+
+func (this *fakeServer) expTime(exp uint32) time.Time { /*{{{*/
+	if exp == 0 {
+		return time.Time{}
+	}
+	if exp > maxRelativeExpire {
+		return time.Unix(int64(exp), 0)
+	}
+	return time.Now().Add(time.Duration(exp) * time.Second)
+} /*}}}*/
+
+func (this *fakeServer) lookup(key string) *fakeItem { /*{{{*/
+	item := this.items[key]
+	if item != nil && !item.exp.IsZero() && time.Now().After(item.exp) {
+		delete(this.items, key)
+		return nil
+	}
+	return item
+} /*}}}*/
+
+func (this *fakeServer) handle(op opcode_t, ext []byte, key string, val []byte, cas uint64) (status status_t, rext []byte, rval []byte, rcas uint64) { /*{{{*/
+	switch op {
+	case OP_GET, OP_GETQ, OP_GETK, OP_GETKQ:
+		item := this.lookup(key)
+		if item == nil {
+			return STATUS_KEY_ENOENT, nil, []byte("Not found"), 0
+		}
+		return STATUS_SUCCESS, binary.BigEndian.AppendUint32(nil, item.flags), item.val, item.cas
+	case OP_SET, OP_ADD, OP_REPLACE, OP_SETQ, OP_ADDQ, OP_REPLACEQ:
+		item := this.lookup(key)
+		if (op == OP_ADD || op == OP_ADDQ) && item != nil {
+			return STATUS_KEY_EEXISTS, nil, nil, 0
+		}
+		if (op == OP_REPLACE || op == OP_REPLACEQ) && item == nil {
+			return STATUS_KEY_ENOENT, nil, nil, 0
+		}
+		if cas != 0 {
+			if item == nil {
+				return STATUS_KEY_ENOENT, nil, nil, 0
+			}
+			if item.cas != cas {
+				return STATUS_KEY_EEXISTS, nil, nil, 0
+			}
+		}
+		this.cas++
+		this.items[key] = &fakeItem{
+			flags: binary.BigEndian.Uint32(ext[0:4]),
+			val:   append([]byte(nil), val...),
+			cas:   this.cas,
+			exp:   this.expTime(binary.BigEndian.Uint32(ext[4:8])),
+		}
+		return STATUS_SUCCESS, nil, nil, this.cas
+	case OP_DELETE, OP_DELETEQ:
+		item := this.lookup(key)
+		if item == nil {
+			return STATUS_KEY_ENOENT, nil, nil, 0
+		}
+		if cas != 0 && item.cas != cas {
+			return STATUS_KEY_EEXISTS, nil, nil, 0
+		}
+		delete(this.items, key)
+		return STATUS_SUCCESS, nil, nil, 0
+	case OP_INCREMENT, OP_DECREMENT:
+		delta := binary.BigEndian.Uint64(ext[0:8])
+		initial := binary.BigEndian.Uint64(ext[8:16])
+		exp := binary.BigEndian.Uint32(ext[16:20])
+		item := this.lookup(key)
+		if item == nil {
+			if exp == 0xffffffff {
+				return STATUS_KEY_ENOENT, nil, nil, 0
+			}
+			this.cas++
+			this.items[key] = &fakeItem{val: []byte(strconv.FormatUint(initial, 10)), cas: this.cas, exp: this.expTime(exp)}
+			return STATUS_SUCCESS, nil, binary.BigEndian.AppendUint64(nil, initial), this.cas
+		}
+		if cas != 0 && item.cas != cas {
+			return STATUS_KEY_EEXISTS, nil, nil, 0
+		}
+		n, err := strconv.ParseUint(string(item.val), 10, 64)
+		if err != nil {
+			return STATUS_DELTA_BADVAL, nil, nil, 0
+		}
+		if op == OP_INCREMENT {
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		this.cas++
+		item.val = []byte(strconv.FormatUint(n, 10))
+		item.cas = this.cas
+		return STATUS_SUCCESS, nil, binary.BigEndian.AppendUint64(nil, n), this.cas
+	case OP_APPEND, OP_PREPEND:
+		item := this.lookup(key)
+		if item == nil {
+			return STATUS_NOT_STORED, nil, nil, 0
+		}
+		if op == OP_APPEND {
+			item.val = append(item.val, val...)
+		} else {
+			item.val = append(append([]byte(nil), val...), item.val...)
+		}
+		this.cas++
+		item.cas = this.cas
+		return STATUS_SUCCESS, nil, nil, this.cas
+	case OP_FLUSH:
+		this.items = make(map[string]*fakeItem)
+		return STATUS_SUCCESS, nil, nil, 0
+	case OP_NOOP:
+		return STATUS_SUCCESS, nil, nil, 0
+	case OP_VERSION:
+		return STATUS_SUCCESS, nil, []byte("1.6.0-fake"), 0
+	}
+	return STATUS_UNKNOWN_COMMAND, nil, nil, 0
+} /*}}}*/
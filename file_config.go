@@ -0,0 +1,205 @@
+package memcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+//server列表配置文件，NewMemcacheFromFile使用JSON格式，YAML格式使用yamlconfig子包
+//
+//	{
+//	    "servers": [
+//	        {"address": "10.0.1.5:11211", "weight": 50, "max_conn": 64, "init_conn": 4, "idle_time": "30m"}
+//	    ],
+//	    "dial_timeout": "1s",
+//	    "read_timeout": "500ms",
+//	    "write_timeout": "500ms"
+//	}
+type FileConfig struct {
+	Servers      []FileServerConfig `json:"servers" yaml:"servers"`
+	DialTimeout  ConfigDuration     `json:"dial_timeout" yaml:"dial_timeout"`
+	ReadTimeout  ConfigDuration     `json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout ConfigDuration     `json:"write_timeout" yaml:"write_timeout"`
+}
+
+type FileServerConfig struct {
+	Address  string         `json:"address" yaml:"address"`
+	Weight   int            `json:"weight" yaml:"weight"`
+	MaxConn  int            `json:"max_conn" yaml:"max_conn"`
+	InitConn int            `json:"init_conn" yaml:"init_conn"`
+	IdleTime ConfigDuration `json:"idle_time" yaml:"idle_time"`
+}
+
+//解析配置文件内容，未知字段需要返回错误
+type FileDecoder func(data []byte, config *FileConfig) error
+
+//配置文件中的时间，格式同time.ParseDuration，如"500ms"、"2h"
+type ConfigDuration time.Duration
+
+func (this *ConfigDuration) UnmarshalText(text []byte) error { /*{{{*/
+	d, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*this = ConfigDuration(d)
+	return nil
+} /*}}}*/
+
+//检查配置文件修改时间的间隔
+var fileWatchInterval = time.Second * 5
+
+//从JSON配置文件读取server列表创建Memcache，之后定时检查文件修改时间，文件变化时重新加载
+//新的配置校验失败时保留当前配置，文件再次修改后重试
+func NewMemcacheFromFile(path string) (mem *Memcache, err error) { /*{{{*/
+	return NewMemcacheFromFileWith(path, DecodeJSONConfig)
+} /*}}}*/
+
+//同NewMemcacheFromFile，使用decode解析配置文件
+func NewMemcacheFromFileWith(path string, decode FileDecoder) (mem *Memcache, err error) { /*{{{*/
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := LoadFileConfigWith(path, decode)
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err = NewMemcache(config.servers())
+	if err != nil {
+		return nil, err
+	}
+	config.applyTimeout(mem)
+
+	refresher := &fileRefresher{mem: mem, path: path, decode: decode, modTime: info.ModTime(), size: info.Size()}
+	go mem.refreshServers(fileWatchInterval, refresher.lookup, refresher.applied)
+
+	return mem, nil
+} /*}}}*/
+
+//记录已加载的文件修改时间，server列表更新成功后才更新超时设置
+//校验失败时同样记录修改时间，文件再次修改后重试；server列表更新失败时下次检查重试
+type fileRefresher struct {
+	mem     *Memcache
+	path    string
+	decode  FileDecoder
+	modTime time.Time
+	size    int64
+
+	pending     *FileConfig //最近一次加载的配置
+	pendingTime time.Time
+	pendingSize int64
+}
+
+func (this *fileRefresher) lookup() ([]*Server, error) { /*{{{*/
+	info, err := os.Stat(this.path)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(this.modTime) && info.Size() == this.size {
+		//文件未变化时返回空列表，不更新
+		return nil, nil
+	}
+
+	config, err := LoadFileConfigWith(this.path, this.decode)
+	if err != nil {
+		this.modTime, this.size = info.ModTime(), info.Size()
+		return nil, err
+	}
+	this.pending, this.pendingTime, this.pendingSize = config, info.ModTime(), info.Size()
+	return config.servers(), nil
+} /*}}}*/
+
+func (this *fileRefresher) applied() { /*{{{*/
+	if this.pending == nil {
+		return
+	}
+	this.modTime, this.size = this.pendingTime, this.pendingSize
+	this.pending.applyTimeout(this.mem)
+	this.pending = nil
+} /*}}}*/
+
+//读取并校验JSON配置文件，错误可以用errors.Is(err, memcache.ErrInvalConfig)判断
+func LoadFileConfig(path string) (config *FileConfig, err error) { /*{{{*/
+	return LoadFileConfigWith(path, DecodeJSONConfig)
+} /*}}}*/
+
+//同LoadFileConfig，使用decode解析配置文件
+func LoadFileConfigWith(path string, decode FileDecoder) (config *FileConfig, err error) { /*{{{*/
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config = &FileConfig{}
+	if err := decode(data, config); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalConfig, path, err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalConfig, path, err)
+	}
+	return config, nil
+} /*}}}*/
+
+//按JSON格式解析，未知字段返回错误
+func DecodeJSONConfig(data []byte, config *FileConfig) error { /*{{{*/
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+} /*}}}*/
+
+func (this *FileConfig) validate() error { /*{{{*/
+	if len(this.Servers) == 0 {
+		return fmt.Errorf("servers is empty")
+	}
+
+	addresses := make(map[string]bool, len(this.Servers))
+	for i, s := range this.Servers {
+		if _, _, err := parseAddress(s.Address); err != nil {
+			return fmt.Errorf("servers[%d]: invalid address %q", i, s.Address)
+		}
+		if addresses[s.Address] {
+			return fmt.Errorf("servers[%d]: duplicate address %q", i, s.Address)
+		}
+		addresses[s.Address] = true
+
+		if s.Weight < 0 || s.MaxConn < 0 || s.InitConn < 0 || s.IdleTime < 0 {
+			return fmt.Errorf("servers[%d]: negative value", i)
+		}
+		if s.MaxConn > 0 && s.InitConn > s.MaxConn {
+			return fmt.Errorf("servers[%d]: init_conn > max_conn", i)
+		}
+	}
+
+	if this.DialTimeout < 0 || this.ReadTimeout < 0 || this.WriteTimeout < 0 {
+		return fmt.Errorf("negative timeout")
+	}
+	return nil
+} /*}}}*/
+
+func (this *FileConfig) servers() []*Server { /*{{{*/
+	server_list := make([]*Server, 0, len(this.Servers))
+	for _, s := range this.Servers {
+		server_list = append(server_list, &Server{
+			Address:  s.Address,
+			Weight:   s.Weight,
+			MaxConn:  s.MaxConn,
+			InitConn: s.InitConn,
+			IdleTime: time.Duration(s.IdleTime),
+		})
+	}
+	return server_list
+} /*}}}*/
+
+//配置了任一超时时间时更新超时设置
+func (this *FileConfig) applyTimeout(mem *Memcache) { /*{{{*/
+	if this.DialTimeout == 0 && this.ReadTimeout == 0 && this.WriteTimeout == 0 {
+		return
+	}
+	mem.SetTimeout(time.Duration(this.DialTimeout), time.Duration(this.ReadTimeout), time.Duration(this.WriteTimeout))
+} /*}}}*/
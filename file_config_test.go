@@ -0,0 +1,176 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//写入配置文件，修改时间每次递增，避免文件系统时间精度导致修改未被发现
+func writeConfig(t *testing.T, path string, content string) { /*{{{*/
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mod_time := time.Now().Add(time.Second)
+	if info, err := os.Stat(path); err == nil && !info.ModTime().Before(mod_time) {
+		mod_time = info.ModTime().Add(time.Second)
+	}
+	if err := os.Chtimes(path, mod_time, mod_time); err != nil {
+		t.Fatal(err)
+	}
+} /*}}}*/
+
+func TestLoadFileConfig(t *testing.T) { /*{{{*/
+	path := filepath.Join(t.TempDir(), "servers.json")
+
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"valid", `{"servers":[{"address":"10.0.0.1:11211","weight":10,"max_conn":8,"init_conn":2,"idle_time":"30m"}],"read_timeout":"500ms"}`, true},
+		{"unknown field", `{"servers":[{"address":"10.0.0.1:11211"}],"timeout":"1s"}`, false},
+		{"no servers", `{"servers":[]}`, false},
+		{"invalid address", `{"servers":[{"address":"::1:11211"}]}`, false},
+		{"duplicate address", `{"servers":[{"address":"10.0.0.1:11211"},{"address":"10.0.0.1:11211"}]}`, false},
+		{"init_conn > max_conn", `{"servers":[{"address":"10.0.0.1:11211","max_conn":2,"init_conn":4}]}`, false},
+		{"invalid duration", `{"servers":[{"address":"10.0.0.1:11211","idle_time":"30"}]}`, false},
+		{"negative timeout", `{"servers":[{"address":"10.0.0.1:11211"}],"dial_timeout":"-1s"}`, false},
+	}
+
+	for _, tt := range tests {
+		writeConfig(t, path, tt.content)
+		config, err := LoadFileConfig(path)
+		if !tt.valid {
+			if !errors.Is(err, ErrInvalConfig) {
+				t.Errorf("%s: err = %v, want ErrInvalConfig", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		s := config.Servers[0]
+		if s.Weight != 10 || s.MaxConn != 8 || s.InitConn != 2 || time.Duration(s.IdleTime) != time.Minute*30 || time.Duration(config.ReadTimeout) != time.Millisecond*500 {
+			t.Errorf("%s: config = %+v", tt.name, config)
+		}
+	}
+} /*}}}*/
+
+func TestFileConfigReload(t *testing.T) { /*{{{*/
+	interval := fileWatchInterval
+	fileWatchInterval = time.Millisecond * 10
+	t.Cleanup(func() {
+		fileWatchInterval = interval
+		dialTimeout.Store(0)
+		readTimeout.Store(0)
+		writeTimeout.Store(0)
+	})
+
+	f1, f2 := newFake(t), newFake(t)
+	path := filepath.Join(t.TempDir(), "servers.json")
+	writeConfig(t, path, fmt.Sprintf(`{"servers":[{"address":%q,"max_conn":4,"init_conn":1}],"read_timeout":"1s"}`, f1.addr()))
+
+	mc, err := NewMemcacheFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	if time.Duration(readTimeout.Load()) != time.Second {
+		t.Fatalf("readTimeout = %v, want 1s", time.Duration(readTimeout.Load()))
+	}
+	mc.manager.Lock()
+	old := mc.manager.serverList[0]
+	mc.manager.Unlock()
+
+	//校验失败时保留当前配置
+	writeConfig(t, path, `{"servers":[{"address":"::1:11211"}]}`)
+	time.Sleep(time.Millisecond * 50)
+	if got := serverAddrs(mc); got != f1.addr() {
+		t.Fatalf("servers = %s after invalid config, want %s", got, f1.addr())
+	}
+
+	//连接池配置变化时重新创建连接池
+	writeConfig(t, path, fmt.Sprintf(`{"servers":[{"address":%q,"max_conn":16,"init_conn":1},{"address":%q,"init_conn":1}],"read_timeout":"2s"}`, f1.addr(), f2.addr()))
+	waitServers(t, mc, joinAddrs(f1, f2))
+
+	mc.manager.Lock()
+	var s1 *Server
+	for _, s := range mc.manager.serverList {
+		if s.Address == f1.addr() {
+			s1 = s
+		}
+	}
+	mc.manager.Unlock()
+	if s1 == old || s1.MaxConn != 16 || s1.pool.maxCnt != 16 {
+		t.Fatalf("pool of %s not rebuilt: MaxConn = %d", f1.addr(), s1.MaxConn)
+	}
+	if time.Duration(readTimeout.Load()) != time.Second*2 {
+		t.Fatalf("readTimeout = %v, want 2s", time.Duration(readTimeout.Load()))
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := mc.Set(fmt.Sprintf("key_%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+} /*}}}*/
+
+//server列表更新成功后才应用超时设置，更新失败时下次检查重新加载
+func TestFileRefresherTimeout(t *testing.T) { /*{{{*/
+	t.Cleanup(func() {
+		dialTimeout.Store(0)
+		readTimeout.Store(0)
+		writeTimeout.Store(0)
+	})
+
+	f1, f2 := newFake(t), newFake(t)
+	path := filepath.Join(t.TempDir(), "servers.json")
+	writeConfig(t, path, fmt.Sprintf(`{"servers":[{"address":%q,"init_conn":1}],"read_timeout":"1s"}`, f1.addr()))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mc, _ := newFakeClient(t, 1)
+	mc.SetTimeout(0, time.Second, 0)
+	refresher := &fileRefresher{mem: mc, path: path, decode: DecodeJSONConfig, modTime: info.ModTime(), size: info.Size()}
+	if list, err := refresher.lookup(); err != nil || list != nil {
+		t.Fatalf("unchanged file: %v %v", list, err)
+	}
+
+	writeConfig(t, path, fmt.Sprintf(`{"servers":[{"address":%q,"init_conn":1}],"read_timeout":"2s"}`, f2.addr()))
+	for i := 0; i < 2; i++ {
+		list, err := refresher.lookup()
+		if err != nil || len(list) != 1 || list[0].Address != f2.addr() {
+			t.Fatalf("lookup %d before applied: %v %v", i, list, err)
+		}
+		if time.Duration(readTimeout.Load()) != time.Second {
+			t.Fatalf("readTimeout = %v before applied, want 1s", time.Duration(readTimeout.Load()))
+		}
+	}
+
+	refresher.applied()
+	if time.Duration(readTimeout.Load()) != time.Second*2 {
+		t.Fatalf("readTimeout = %v after applied, want 2s", time.Duration(readTimeout.Load()))
+	}
+	if list, err := refresher.lookup(); err != nil || list != nil {
+		t.Fatalf("lookup after applied: %v %v", list, err)
+	}
+
+	//校验失败时不应用超时设置，文件未再次修改时不重复加载
+	writeConfig(t, path, `{"servers":[],"read_timeout":"3s"}`)
+	if _, err := refresher.lookup(); !errors.Is(err, ErrInvalConfig) {
+		t.Fatalf("invalid config err = %v", err)
+	}
+	if list, err := refresher.lookup(); err != nil || list != nil {
+		t.Fatalf("lookup after invalid config: %v %v", list, err)
+	}
+	if time.Duration(readTimeout.Load()) != time.Second*2 {
+		t.Fatalf("readTimeout = %v after invalid config, want 2s", time.Duration(readTimeout.Load()))
+	}
+} /*}}}*/
@@ -0,0 +1,257 @@
+//子包测试使用的memcached binary协议server，数据保存在内存中
+//支持get/set/add/replace/delete/incr/decr及其quiet命令、noop、version，与根目录fake_test.go的fakeServer相同
+package fakemc
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pangudashu/memcache"
+)
+
+//memcached过期时间超过30天时按unix时间戳处理
+const maxRelativeExpire = 60 * 60 * 24 * 30
+
+type item struct {
+	flags uint32
+	val   []byte
+	cas   uint64
+	exp   time.Time
+}
+
+type Server struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	items map[string]*item
+	cas   uint64
+	ops   int
+}
+
+//监听127.0.0.1的随机端口，测试结束时关闭
+func New(t testing.TB) *Server { /*{{{*/
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{ln: ln, items: make(map[string]*item)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+} /*}}}*/
+
+//创建n个Server及使用它们的Memcache
+func NewClient(t testing.TB, n int) (*memcache.Memcache, []*Server) { /*{{{*/
+	var servers []*Server
+	var list []*memcache.Server
+	for i := 0; i < n; i++ {
+		s := New(t)
+		servers = append(servers, s)
+		list = append(list, &memcache.Server{Address: s.Addr(), InitConn: 1})
+	}
+	mc, err := memcache.NewMemcache(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mc.Close)
+	return mc, servers
+} /*}}}*/
+
+func (this *Server) Addr() string { /*{{{*/
+	return this.ln.Addr().String()
+} /*}}}*/
+
+//收到的请求数
+func (this *Server) Ops() int { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.ops
+} /*}}}*/
+
+//删除key，模拟数据被淘汰
+func (this *Server) Evict(key string) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	delete(this.items, key)
+} /*}}}*/
+
+func (this *Server) serve(c net.Conn) { /*{{{*/
+	defer c.Close()
+	hdr := make([]byte, 24)
+	for {
+		if _, err := io.ReadFull(c, hdr); err != nil {
+			return
+		}
+		if hdr[0] != byte(memcache.MAGIC_REQ) {
+			return
+		}
+		op := hdr[1]
+		keylen := int(binary.BigEndian.Uint16(hdr[2:4]))
+		extlen := int(hdr[4])
+		bodylen := int(binary.BigEndian.Uint32(hdr[8:12]))
+		opaque := binary.BigEndian.Uint32(hdr[12:16])
+		cas := binary.BigEndian.Uint64(hdr[16:24])
+		body := make([]byte, bodylen)
+		if _, err := io.ReadFull(c, body); err != nil {
+			return
+		}
+		ext := body[:extlen]
+		key := string(body[extlen : extlen+keylen])
+		val := body[extlen+keylen:]
+
+		this.mu.Lock()
+		this.ops++
+		status, rext, rval, rcas := this.handle(op, ext, key, val, cas)
+		this.mu.Unlock()
+
+		//quiet命令成功时不返回，GETQ/GETKQ未命中时不返回
+		switch op {
+		case byte(memcache.OP_GETQ), byte(memcache.OP_GETKQ):
+			if status == uint16(memcache.STATUS_KEY_ENOENT) {
+				continue
+			}
+		case byte(memcache.OP_SETQ), byte(memcache.OP_ADDQ), byte(memcache.OP_REPLACEQ), byte(memcache.OP_DELETEQ):
+			if status == uint16(memcache.STATUS_SUCCESS) {
+				continue
+			}
+		}
+		rkey := ""
+		if op == byte(memcache.OP_GETK) || op == byte(memcache.OP_GETKQ) {
+			rkey = key
+		}
+
+		resp := make([]byte, 24+len(rext)+len(rkey)+len(rval))
+		resp[0] = byte(memcache.MAGIC_RES)
+		resp[1] = op
+		binary.BigEndian.PutUint16(resp[2:4], uint16(len(rkey)))
+		resp[4] = byte(len(rext))
+		binary.BigEndian.PutUint16(resp[6:8], status)
+		binary.BigEndian.PutUint32(resp[8:12], uint32(len(rext)+len(rkey)+len(rval)))
+		binary.BigEndian.PutUint32(resp[12:16], opaque)
+		binary.BigEndian.PutUint64(resp[16:24], rcas)
+		copy(resp[24:], rext)
+		copy(resp[24+len(rext):], rkey)
+		copy(resp[24+len(rext)+len(rkey):], rval)
+		if _, err := c.Write(resp); err != nil {
+			return
+		}
+	}
+} /*}}}*/
+
+func (this *Server) expTime(exp uint32) time.Time { /*{{{*/
+	if exp == 0 {
+		return time.Time{}
+	}
+	if exp > maxRelativeExpire {
+		return time.Unix(int64(exp), 0)
+	}
+	return time.Now().Add(time.Duration(exp) * time.Second)
+} /*}}}*/
+
+func (this *Server) lookup(key string) *item { /*{{{*/
+	it := this.items[key]
+	if it != nil && !it.exp.IsZero() && time.Now().After(it.exp) {
+		delete(this.items, key)
+		return nil
+	}
+	return it
+} /*}}}*/
+
+func (this *Server) handle(op byte, ext []byte, key string, val []byte, cas uint64) (status uint16, rext []byte, rval []byte, rcas uint64) { /*{{{*/
+	var (
+		success   = uint16(memcache.STATUS_SUCCESS)
+		not_found = uint16(memcache.STATUS_KEY_ENOENT)
+		exists    = uint16(memcache.STATUS_KEY_EEXISTS)
+	)
+
+	switch op {
+	case byte(memcache.OP_GET), byte(memcache.OP_GETQ), byte(memcache.OP_GETK), byte(memcache.OP_GETKQ):
+		it := this.lookup(key)
+		if it == nil {
+			return not_found, nil, []byte("Not found"), 0
+		}
+		return success, binary.BigEndian.AppendUint32(nil, it.flags), it.val, it.cas
+	case byte(memcache.OP_SET), byte(memcache.OP_ADD), byte(memcache.OP_REPLACE), byte(memcache.OP_SETQ), byte(memcache.OP_ADDQ), byte(memcache.OP_REPLACEQ):
+		it := this.lookup(key)
+		if (op == byte(memcache.OP_ADD) || op == byte(memcache.OP_ADDQ)) && it != nil {
+			return exists, nil, nil, 0
+		}
+		if (op == byte(memcache.OP_REPLACE) || op == byte(memcache.OP_REPLACEQ)) && it == nil {
+			return not_found, nil, nil, 0
+		}
+		if cas != 0 {
+			if it == nil {
+				return not_found, nil, nil, 0
+			}
+			if it.cas != cas {
+				return exists, nil, nil, 0
+			}
+		}
+		this.cas++
+		this.items[key] = &item{
+			flags: binary.BigEndian.Uint32(ext[0:4]),
+			val:   append([]byte(nil), val...),
+			cas:   this.cas,
+			exp:   this.expTime(binary.BigEndian.Uint32(ext[4:8])),
+		}
+		return success, nil, nil, this.cas
+	case byte(memcache.OP_DELETE), byte(memcache.OP_DELETEQ):
+		it := this.lookup(key)
+		if it == nil {
+			return not_found, nil, nil, 0
+		}
+		if cas != 0 && it.cas != cas {
+			return exists, nil, nil, 0
+		}
+		delete(this.items, key)
+		return success, nil, nil, 0
+	case byte(memcache.OP_INCREMENT), byte(memcache.OP_DECREMENT):
+		delta := binary.BigEndian.Uint64(ext[0:8])
+		initial := binary.BigEndian.Uint64(ext[8:16])
+		exp := binary.BigEndian.Uint32(ext[16:20])
+		it := this.lookup(key)
+		if it == nil {
+			if exp == memcache.CounterNoCreate {
+				return not_found, nil, nil, 0
+			}
+			this.cas++
+			this.items[key] = &item{val: []byte(strconv.FormatUint(initial, 10)), cas: this.cas, exp: this.expTime(exp)}
+			return success, nil, binary.BigEndian.AppendUint64(nil, initial), this.cas
+		}
+		if cas != 0 && it.cas != cas {
+			return exists, nil, nil, 0
+		}
+		n, err := strconv.ParseUint(string(it.val), 10, 64)
+		if err != nil {
+			return uint16(memcache.STATUS_DELTA_BADVAL), nil, nil, 0
+		}
+		if op == byte(memcache.OP_INCREMENT) {
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		this.cas++
+		it.val = []byte(strconv.FormatUint(n, 10))
+		it.cas = this.cas
+		return success, nil, binary.BigEndian.AppendUint64(nil, n), this.cas
+	case byte(memcache.OP_NOOP):
+		return success, nil, nil, 0
+	case byte(memcache.OP_VERSION):
+		return success, nil, []byte("1.6.0-fake"), 0
+	}
+	return uint16(memcache.STATUS_UNKNOWN_COMMAND), nil, nil, 0
+} /*}}}*/
@@ -0,0 +1,114 @@
+package memcache
+
+import (
+	"errors"
+)
+
+type Item struct {
+	Key        string
+	Value      interface{} //同Set的value，Flags非0时为[]byte或与Flags类型一致的值
+	Flags      uint32      //0表示按Value类型自动设置，非0时Value按原样存储，不能使用client保留的16384、32768、65536
+	Expiration uint32      //过期时间，可以使用ExpireIn、ExpireAt转换
+	CAS        uint64      //数据版本号，非0时只有服务端cas一致才会写入
+}
+
+//读取key，返回的Item包含flags、cas
+//存储的value为map、结构体时Value返回format，没有format或flags不是本client写入的类型时Value返回原始[]byte
+func (this *Memcache) GetItem(key string, format ...interface{}) (item *Item, err error) { /*{{{*/
+	item, _, err = this.getItem(key, format...)
+	return item, err
+} /*}}}*/
+
+//同GetItem，负缓存标记、tag已失效的数据按未命中返回ErrNotFound，stale返回其cas，写入时需要使用cas覆盖
+func (this *Memcache) getItem(key string, format ...interface{}) (item *Item, stale uint64, err error) { /*{{{*/
+	res, err := this.get(key, format...)
+	if errors.Is(err, ErrNoFormat) && res != nil {
+		res.body, err = res.value(), nil
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) && res != nil && (res.flags == VALUE_TYPE_NEGATIVE || res.flags == VALUE_TYPE_TAGGED) {
+			return nil, res.header.cas, err
+		}
+		return nil, 0, err
+	}
+
+	item = &Item{
+		Key:   key,
+		Value: res.body,
+		Flags: uint32(res.flags),
+		CAS:   res.header.cas,
+	}
+	if res.body == nil && len(format) > 0 {
+		item.Value = format[0]
+	}
+
+	return item, 0, nil
+} /*}}}*/
+
+//写入item，返回服务端生成的新cas
+func (this *Memcache) SetItem(item *Item) (cas uint64, err error) { /*{{{*/
+	return this.storeItem(OP_SET, item)
+} /*}}}*/
+
+//key不存在时写入item，返回服务端生成的新cas
+func (this *Memcache) AddItem(item *Item) (cas uint64, err error) { /*{{{*/
+	return this.storeItem(OP_ADD, item)
+} /*}}}*/
+
+//key存在时替换item，返回服务端生成的新cas
+func (this *Memcache) ReplaceItem(item *Item) (cas uint64, err error) { /*{{{*/
+	return this.storeItem(OP_REPLACE, item)
+} /*}}}*/
+
+//item.CAS与服务端一致时写入，数据已被其它client更新时返回ErrKeyExists，key不存在时返回ErrNotFound
+func (this *Memcache) CompareAndSwap(item *Item) (cas uint64, err error) { /*{{{*/
+	if item.CAS == 0 {
+		return 0, newOpError(OP_SET, item.Key, nil, ErrInval)
+	}
+	return this.storeItem(OP_SET, item)
+} /*}}}*/
+
+func (this *Memcache) storeItem(opcode opcode_t, item *Item) (cas uint64, err error) { /*{{{*/
+	real_key, err := this.storageKey(opcode, item.Key)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkExpire(item.Expiration); err != nil {
+		return 0, newOpError(opcode, real_key, nil, err)
+	}
+	if real_key != item.Key {
+		real_item := *item
+		real_item.Key = real_key
+		item = &real_item
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
+
+	server := this.nodes.getServerByKey(item.Key)
+	call := this.begin(this.ctx, opcode, item.Key, server)
+	defer this.finish(&call, &err)
+	if server == nil {
+		return 0, newOpError(opcode, item.Key, nil, ErrNotConn)
+	}
+
+	for ; call.tries < badTryCnt; call.tries++ {
+		conn, e := server.pool.Get()
+		if e != nil {
+			this.sendBadServerNotice()
+			return 0, newOpError(opcode, item.Key, server, e)
+		}
+
+		cas, err = conn.storeItem(opcode, item)
+		call.size = conn.valueLen
+
+		if connBroken(err) {
+			server.pool.Release(conn)
+		} else {
+			server.pool.Put(conn)
+			break
+		}
+	}
+
+	return cas, err
+} /*}}}*/
@@ -0,0 +1,136 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestItemReservedFlags(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	//保留的flags不能由用户写入
+	for _, flags := range []value_type_t{VALUE_TYPE_NEGATIVE, VALUE_TYPE_STREAM, VALUE_TYPE_TAGGED} {
+		_, err := mc.SetItem(&Item{Key: "reserved", Value: []byte("value"), Flags: uint32(flags)})
+		if !errors.Is(err, ErrInvalValue) {
+			t.Errorf("SetItem flags %d: err = %v, want ErrInvalValue", flags, err)
+		}
+		errs := mc.SetMulti([]*Item{{Key: "reserved", Value: []byte("value"), Flags: uint32(flags)}})
+		if !errors.Is(errs["reserved"], ErrInvalValue) {
+			t.Errorf("SetMulti flags %d: errs = %v, want ErrInvalValue", flags, errs)
+		}
+	}
+
+	//包含保留位的自定义flags按原样读写，不会被当作负缓存、tag
+	for _, flags := range []uint32{uint32(VALUE_TYPE_NEGATIVE) | 1, uint32(VALUE_TYPE_TAGGED) | uint32(VALUE_TYPE_NEGATIVE), 0xffffffff} {
+		if _, err := mc.SetItem(&Item{Key: "custom", Value: []byte("value"), Flags: flags}); err != nil {
+			t.Fatalf("SetItem flags %#x: %v", flags, err)
+		}
+		item, err := mc.GetItem("custom")
+		if err != nil {
+			t.Fatalf("GetItem flags %#x: %v", flags, err)
+		}
+		if item.Flags != flags || string(item.Value.([]byte)) != "value" {
+			t.Errorf("GetItem flags %#x: got flags %#x value %v", flags, item.Flags, item.Value)
+		}
+		if _, _, err := mc.Get("custom"); errors.Is(err, ErrNotFound) {
+			t.Errorf("Get flags %#x: treated as negative cache", flags)
+		}
+		if _, _, err := mc.GetInto("custom", nil); errors.Is(err, ErrNotFound) {
+			t.Errorf("GetInto flags %#x: treated as negative cache", flags)
+		}
+	}
+} /*}}}*/
+
+type itemUser struct {
+	Name string
+	Age  int
+}
+
+//GetItem返回的Item修改Value后可以直接用CompareAndSwap写回
+func TestItemCompareAndSwap(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	tests := []struct {
+		name   string
+		value  interface{}
+		format interface{}
+		update func(v interface{}) interface{}
+		check  func(v interface{}) bool
+	}{
+		{
+			name:   "string",
+			value:  "old",
+			update: func(v interface{}) interface{} { return v.(string) + "_new" },
+			check:  func(v interface{}) bool { return v == "old_new" },
+		},
+		{
+			name:   "int",
+			value:  10,
+			update: func(v interface{}) interface{} { return v.(int) + 1 },
+			check:  func(v interface{}) bool { return v == 11 },
+		},
+		{
+			name:   "int64",
+			value:  int64(10),
+			update: func(v interface{}) interface{} { return v.(int64) + 1 },
+			check:  func(v interface{}) bool { return v == int64(11) },
+		},
+		{
+			name:   "struct",
+			value:  &itemUser{Name: "a", Age: 1},
+			format: &itemUser{},
+			update: func(v interface{}) interface{} {
+				u := v.(*itemUser)
+				u.Age++
+				return u
+			},
+			check: func(v interface{}) bool {
+				u := v.(*itemUser)
+				return u.Name == "a" && u.Age == 2
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		key := "cas_" + tt.name
+		if _, err := mc.Set(key, tt.value); err != nil {
+			t.Fatalf("%s: Set: %v", tt.name, err)
+		}
+
+		var format []interface{}
+		if tt.format != nil {
+			format = append(format, tt.format)
+		}
+		item, err := mc.GetItem(key, format...)
+		if err != nil {
+			t.Fatalf("%s: GetItem: %v", tt.name, err)
+		}
+		item.Value = tt.update(item.Value)
+		if _, err := mc.CompareAndSwap(item); err != nil {
+			t.Fatalf("%s: CompareAndSwap: %v", tt.name, err)
+		}
+
+		//cas已变化，旧Item不能再次写入
+		if _, err := mc.CompareAndSwap(item); !errors.Is(err, ErrKeyExists) {
+			t.Errorf("%s: stale CompareAndSwap err = %v, want ErrKeyExists", tt.name, err)
+		}
+
+		if tt.format != nil {
+			format = []interface{}{&itemUser{}}
+		}
+		item, err = mc.GetItem(key, format...)
+		if err != nil || !tt.check(item.Value) {
+			t.Errorf("%s: GetItem after CAS = %v, %v", tt.name, item, err)
+		}
+	}
+
+	//Value类型与Flags不一致时拒绝写入
+	item, err := mc.GetItem("cas_string")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item.Value = 1
+	if _, err := mc.CompareAndSwap(item); !errors.Is(err, ErrInvalValue) {
+		t.Errorf("mismatched Value err = %v, want ErrInvalValue", err)
+	}
+} /*}}}*/
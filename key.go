@@ -0,0 +1,127 @@
+package memcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+//memcached允许的最大key长度
+const maxKeyLength = 250
+
+//开启后不合法的key(超过250字节、包含空格或控制字符)替换为"sha256:"加key的sha256，可以直接使用URL、组合key
+//关闭时不合法的key返回ErrMalformedKey，空key始终返回ErrMalformedKey
+func (this *Memcache) SetKeyHashing(enable bool) { /*{{{*/
+	this.hashKeys = enable
+} /*}}}*/
+
+//key是否可以直接发送，兼容文本协议：1~250字节，不包含空格及控制字符
+func validKey(key string) bool { /*{{{*/
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c <= ' ' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+} /*}}}*/
+
+func hashKey(key string) string { /*{{{*/
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+} /*}}}*/
+
+//返回实际发送的key：加上namespace前缀，开启SetKeyHashing时替换不合法的key
+func (this *Memcache) storageKey(opcode opcode_t, key string) (string, error) { /*{{{*/
+	if key == "" {
+		return key, newOpError(opcode, key, nil, ErrMalformedKey)
+	}
+
+	real_key, err := this.namespaceKey(key)
+	if err != nil {
+		return key, err
+	}
+	if validKey(real_key) {
+		return real_key, nil
+	}
+	if this.hashKeys {
+		return hashKey(real_key), nil
+	}
+	return key, newOpError(opcode, key, nil, ErrMalformedKey)
+} /*}}}*/
+
+//批量命令的key转换，real_keys与keys一一对应，转换失败的为空字符串，错误记录到errs
+//所有key都不需要转换时real_keys为nil
+func (this *Memcache) storageKeys(opcode opcode_t, keys []string) (real_keys []string, errs map[string]error) { /*{{{*/
+	for i, key := range keys {
+		real_key, err := this.storageKey(opcode, key)
+		if real_keys == nil {
+			if err == nil && real_key == key {
+				continue
+			}
+			real_keys = make([]string, len(keys))
+			copy(real_keys, keys[:i])
+		}
+
+		if err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[key] = err
+			if !errors.Is(err, ErrMalformedKey) {
+				//namespace版本号读取失败，其它key同样失败
+				for _, k := range keys[i+1:] {
+					errs[k] = err
+				}
+				return real_keys, errs
+			}
+			continue
+		}
+		real_keys[i] = real_key
+	}
+	return real_keys, errs
+} /*}}}*/
+
+//需要发送的key及实际存储的key => 原key
+func originKeys(keys []string, real_keys []string) (send_keys []string, origin map[string]string) { /*{{{*/
+	send_keys = make([]string, 0, len(keys))
+	origin = make(map[string]string, len(keys))
+	for i, real_key := range real_keys {
+		if real_key == "" {
+			continue
+		}
+		send_keys = append(send_keys, real_key)
+		origin[real_key] = keys[i]
+	}
+	return send_keys, origin
+} /*}}}*/
+
+//批量命令的结果还原为原key
+func restoreKeys[T any](res map[string]T, origin map[string]string) map[string]T { /*{{{*/
+	if res == nil {
+		return nil
+	}
+	restored := make(map[string]T, len(res))
+	for k, v := range res {
+		if key, ok := origin[k]; ok {
+			restored[key] = v
+		}
+	}
+	return restored
+} /*}}}*/
+
+//合并key转换失败的错误，都为空时返回nil
+func mergeKeyErrors(errs map[string]error, key_errs map[string]error) map[string]error { /*{{{*/
+	if len(key_errs) == 0 {
+		return errs
+	}
+	if errs == nil {
+		errs = make(map[string]error, len(key_errs))
+	}
+	for key, err := range key_errs {
+		errs[key] = err
+	}
+	return errs
+} /*}}}*/
@@ -0,0 +1,94 @@
+package memcache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) { /*{{{*/
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"", false},
+		{"key", true},
+		{"user:1|name", true},
+		{strings.Repeat("k", maxKeyLength), true},
+		{strings.Repeat("k", maxKeyLength+1), false},
+		{"a b", false},
+		{"a\tb", false},
+		{"a\r\nb", false},
+		{"a\x00b", false},
+		{"a\x7fb", false},
+		{"键", true},
+	}
+	for _, tt := range tests {
+		if got := validKey(tt.key); got != tt.want {
+			t.Errorf("validKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+} /*}}}*/
+
+func TestKeyHashing(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+	long_key := "https://example.com/" + strings.Repeat("path/", 60)
+	space_key := "user 1 profile"
+	keys := []string{long_key, space_key, "plain"}
+
+	//关闭时不合法的key不发送
+	for _, key := range []string{"", long_key, space_key} {
+		if _, err := mc.Set(key, "value"); !errors.Is(err, ErrMalformedKey) {
+			t.Errorf("Set(%q) err = %v, want ErrMalformedKey", key, err)
+		}
+		if _, _, err := mc.Get(key); !errors.Is(err, ErrMalformedKey) {
+			t.Errorf("Get(%q) err = %v, want ErrMalformedKey", key, err)
+		}
+	}
+	fs[0].mu.Lock()
+	n := len(fs[0].items)
+	fs[0].mu.Unlock()
+	if n != 0 {
+		t.Fatalf("server has %d items, want 0", n)
+	}
+
+	mc.SetKeyHashing(true)
+	if _, err := mc.Set("", "value"); !errors.Is(err, ErrMalformedKey) {
+		t.Errorf("Set empty key err = %v, want ErrMalformedKey", err)
+	}
+	for _, key := range keys {
+		if _, err := mc.Set(key, key); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+		if value, _, err := mc.Get(key); err != nil || value != key {
+			t.Errorf("Get(%q) = %v, %v", key, value, err)
+		}
+	}
+
+	//只有不合法的key替换为sha256，合法的key原样存储
+	fs[0].mu.Lock()
+	for _, real_key := range []string{hashKey(long_key), hashKey(space_key), "plain"} {
+		if fs[0].items[real_key] == nil {
+			t.Errorf("server missing key %q", real_key)
+		}
+	}
+	fs[0].mu.Unlock()
+
+	//批量命令的结果还原为原key
+	values, err := GetMultiAs[string](mc, append(keys, "missing key"))
+	if err != nil || len(values) != len(keys) {
+		t.Fatalf("GetMultiAs = %v, %v", values, err)
+	}
+	for _, key := range keys {
+		if values[key] != key {
+			t.Errorf("GetMultiAs[%q] = %q", key, values[key])
+		}
+	}
+
+	if errs := mc.DeleteMulti(keys); errs != nil {
+		t.Fatalf("DeleteMulti errs = %v", errs)
+	}
+	if _, _, err := mc.Get(space_key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after DeleteMulti err = %v, want ErrNotFound", err)
+	}
+} /*}}}*/
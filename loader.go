@@ -0,0 +1,84 @@
+package memcache
+
+import (
+	"errors"
+	"reflect"
+)
+
+//负缓存占位值，存储时flags标记为VALUE_TYPE_NEGATIVE
+type negativeValue struct{}
+
+//设置GetOrLoad负缓存有效期(秒)，loader返回ErrNotFound时缓存该结果，0表示不缓存
+func (this *Memcache) SetNegativeTTL(ttl uint32) { /*{{{*/
+	this.negativeTTL = ttl
+} /*}}}*/
+
+//读取key并反序列化到dst，未命中时调用loader加载数据并回填缓存
+//loader返回ErrNotFound表示数据不存在，开启负缓存时将记录该结果，有效期内不再调用loader
+func (this *Memcache) GetOrLoad(key string, dst interface{}, ttl uint32, loader func() (interface{}, error)) error { /*{{{*/
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return ErrInvalFormat
+	}
+
+	res, err := this.get(key, dst)
+	if err == nil {
+		return assignValue(dst, res.body)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if res != nil && res.flags == VALUE_TYPE_NEGATIVE {
+		return err
+	}
+
+	value, err := loader()
+	if errors.Is(err, ErrNotFound) {
+		if this.negativeTTL > 0 {
+			this.fill(key, negativeValue{}, this.negativeTTL, res)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	//回填失败不影响返回结果
+	this.fill(key, value, ttl, res)
+
+	return assignValue(dst, value)
+} /*}}}*/
+
+//回填缓存，使用Add避免覆盖其它client已经写入的新值
+//tag已失效的数据仍存储在服务端，Add总是失败，使用读取时的cas覆盖
+func (this *Memcache) fill(key string, value interface{}, ttl uint32, stale *response) { /*{{{*/
+	if stale != nil && stale.flags == VALUE_TYPE_TAGGED && stale.header.cas != 0 {
+		this.storeItem(OP_SET, &Item{Key: key, Value: value, Expiration: ttl, CAS: stale.header.cas})
+		return
+	}
+	this.Add(key, value, ttl)
+} /*}}}*/
+
+//将value赋值给dst指向的变量，value为nil时表示已直接反序列化到dst
+func assignValue(dst interface{}, value interface{}) error { /*{{{*/
+	if value == nil {
+		return nil
+	}
+
+	dv := reflect.ValueOf(dst).Elem()
+	vv := reflect.ValueOf(value)
+
+	if vv.Kind() == reflect.Ptr && !vv.Type().AssignableTo(dv.Type()) {
+		if vv.IsNil() {
+			return nil
+		}
+		vv = vv.Elem()
+	}
+
+	if !vv.Type().AssignableTo(dv.Type()) {
+		return ErrInvalFormat
+	}
+	dv.Set(vv)
+
+	return nil
+} /*}}}*/
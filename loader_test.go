@@ -0,0 +1,153 @@
+package memcache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type loaderUser struct {
+	ID   int
+	Name string
+}
+
+func TestGetOrLoad(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	var loads atomic.Int32
+	loader := func() (interface{}, error) {
+		loads.Add(1)
+		return &loaderUser{ID: 1, Name: "tom"}, nil
+	}
+
+	//未命中时调用loader并回填
+	var user loaderUser
+	if err := mc.GetOrLoad("user_1", &user, 60, loader); err != nil || user.Name != "tom" || loads.Load() != 1 {
+		t.Fatalf("GetOrLoad miss = %+v, %v, loads %d", user, err, loads.Load())
+	}
+
+	//命中时返回缓存的值，不调用loader
+	var cached loaderUser
+	if err := mc.GetOrLoad("user_1", &cached, 60, loader); err != nil || cached != user || loads.Load() != 1 {
+		t.Fatalf("GetOrLoad hit = %+v, %v, loads %d", cached, err, loads.Load())
+	}
+
+	//已存在的值不会被回填覆盖
+	if _, err := mc.Set("count", int64(7)); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	err := mc.GetOrLoad("count", &count, 60, func() (interface{}, error) {
+		t.Error("loader called on hit")
+		return int64(0), nil
+	})
+	if err != nil || count != 7 {
+		t.Errorf("GetOrLoad count = %d, %v", count, err)
+	}
+
+	//loader出错时不回填
+	load_err := errors.New("db down")
+	var name string
+	if err := mc.GetOrLoad("name", &name, 60, func() (interface{}, error) { return nil, load_err }); !errors.Is(err, load_err) {
+		t.Errorf("GetOrLoad loader err = %v, want %v", err, load_err)
+	}
+	if _, _, err := mc.Get("name"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after loader error err = %v, want ErrNotFound", err)
+	}
+
+	//类型不一致
+	if err := mc.GetOrLoad("name", &name, 60, func() (interface{}, error) { return 1, nil }); !errors.Is(err, ErrInvalFormat) {
+		t.Errorf("GetOrLoad mismatched type err = %v, want ErrInvalFormat", err)
+	}
+	if err := mc.GetOrLoad("name", name, 60, loader); !errors.Is(err, ErrInvalFormat) {
+		t.Errorf("GetOrLoad non-pointer dst err = %v, want ErrInvalFormat", err)
+	}
+} /*}}}*/
+
+func TestGetOrLoadNegative(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	var loads atomic.Int32
+	missing := func() (interface{}, error) {
+		loads.Add(1)
+		return nil, ErrNotFound
+	}
+
+	//未开启负缓存时每次都调用loader
+	var user loaderUser
+	for i := 0; i < 2; i++ {
+		if err := mc.GetOrLoad("user_2", &user, 60, missing); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetOrLoad err = %v, want ErrNotFound", err)
+		}
+	}
+	if loads.Load() != 2 {
+		t.Errorf("loads = %d without negative cache, want 2", loads.Load())
+	}
+
+	mc.SetNegativeTTL(60)
+	loads.Store(0)
+	for i := 0; i < 3; i++ {
+		if err := mc.GetOrLoad("user_2", &user, 60, missing); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetOrLoad err = %v, want ErrNotFound", err)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Errorf("loads = %d with negative cache, want 1", loads.Load())
+	}
+	//负缓存对Get同样按未命中处理
+	if _, _, err := mc.Get("user_2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get negative entry err = %v, want ErrNotFound", err)
+	}
+} /*}}}*/
+
+//并发未命中时各自调用loader，只有第一个回填成功，之后命中该值
+func TestGetOrLoadConcurrent(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	var loads atomic.Int32
+	loader := func() (interface{}, error) {
+		n := loads.Add(1)
+		time.Sleep(time.Millisecond * 20)
+		return "value_" + strconv.Itoa(int(n)), nil
+	}
+
+	const workers = 8
+	results := make([]string, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := mc.GetOrLoad("key", &results[i], 60, loader); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	n := int(loads.Load())
+	if n < 1 || n > workers {
+		t.Fatalf("loads = %d, want 1..%d", n, workers)
+	}
+	for i, value := range results {
+		if k, err := strconv.Atoi(value[len("value_"):]); err != nil || k < 1 || k > n {
+			t.Errorf("worker %d got %q", i, value)
+		}
+	}
+
+	var cached string
+	if err := mc.GetOrLoad("key", &cached, 60, loader); err != nil || int(loads.Load()) != n {
+		t.Fatalf("GetOrLoad after fill = %q, %v, loads %d", cached, err, loads.Load())
+	}
+	//回填使用Add，缓存的是某一个loader的结果，之后的读取都返回该值
+	found := false
+	for _, value := range results {
+		found = found || value == cached
+	}
+	if !found {
+		t.Errorf("cached %q not returned by any loader in %v", cached, results)
+	}
+} /*}}}*/
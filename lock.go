@@ -0,0 +1,119 @@
+package memcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+//LockContext轮询的平均间隔，实际间隔在0.5~1.5倍之间随机，避免多个等待者同时重试
+var lockPollInterval = time.Millisecond * 100
+
+//分布式锁，key的value为随机生成的owner token，只有加锁时的cas一致才能释放、续期
+type Lock struct {
+	mc    *Memcache
+	key   string
+	ttl   uint32
+	token string
+	cas   uint64 //加锁、续期后服务端返回的cas，0表示未持有
+
+	mu sync.Mutex
+}
+
+//创建key对应的锁，ttl为锁的有效期(秒)，持有者异常退出时锁在ttl后自动释放，需要大于0
+//只创建Lock，不加锁，需要调用TryLock或LockContext
+func (this *Memcache) Lock(key string, ttl uint32) *Lock { /*{{{*/
+	return &Lock{mc: this, key: key, ttl: ttl, token: newLockToken()}
+} /*}}}*/
+
+func newLockToken() string { /*{{{*/
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+} /*}}}*/
+
+//尝试加锁，已被其它owner持有时返回false
+func (this *Lock) TryLock() (ok bool, err error) { /*{{{*/
+	if this.ttl == 0 {
+		return false, newOpError(OP_ADD, this.key, nil, ErrInval)
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	cas, err := this.mc.AddItem(&Item{Key: this.key, Value: this.token, Expiration: this.ttl})
+	if errors.Is(err, ErrKeyExists) || errors.Is(err, ErrNotStord) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	this.cas = cas
+	return true, nil
+} /*}}}*/
+
+//阻塞直到加锁成功，ctx取消时返回ctx.Err()
+func (this *Lock) LockContext(ctx context.Context) error { /*{{{*/
+	for {
+		ok, err := this.TryLock()
+		if err != nil || ok {
+			return err
+		}
+
+		wait := lockPollInterval/2 + time.Duration(mrand.Int63n(int64(lockPollInterval)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+} /*}}}*/
+
+//释放锁，使用cas删除，锁已过期或被其它owner持有时返回ErrLockNotHeld
+func (this *Lock) Unlock() error { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.cas == 0 {
+		return newOpError(OP_DELETE, this.key, nil, ErrLockNotHeld)
+	}
+	cas := this.cas
+	this.cas = 0
+
+	_, err := this.mc.Delete(this.key, cas)
+	return lockError(OP_DELETE, this.key, err)
+} /*}}}*/
+
+//续期，使用cas替换，有效期重新设置为ttl，锁已过期或被其它owner持有时返回ErrLockNotHeld
+func (this *Lock) Refresh() error { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.cas == 0 {
+		return newOpError(OP_REPLACE, this.key, nil, ErrLockNotHeld)
+	}
+
+	cas, err := this.mc.ReplaceItem(&Item{Key: this.key, Value: this.token, Expiration: this.ttl, CAS: this.cas})
+	if err != nil {
+		if errors.Is(err, ErrKeyExists) || errors.Is(err, ErrNotFound) {
+			this.cas = 0
+		}
+		return lockError(OP_REPLACE, this.key, err)
+	}
+	this.cas = cas
+	return nil
+} /*}}}*/
+
+//cas不一致、key不存在表示锁已不再持有
+func lockError(opcode opcode_t, key string, err error) error { /*{{{*/
+	if errors.Is(err, ErrKeyExists) || errors.Is(err, ErrNotFound) {
+		return newOpError(opcode, key, nil, ErrLockNotHeld)
+	}
+	return err
+} /*}}}*/
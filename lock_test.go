@@ -0,0 +1,166 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockContention(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	a, b := mc.Lock("job", 10), mc.Lock("job", 10)
+	if ok, err := a.TryLock(); !ok || err != nil {
+		t.Fatalf("a.TryLock = %v, %v", ok, err)
+	}
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("b.TryLock while held = %v, %v", ok, err)
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("b.TryLock after unlock = %v, %v", ok, err)
+	}
+	b.Unlock()
+
+	//同一时间只有一个持有者
+	var holders, acquired atomic.Int32
+	var overlap atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock := mc.Lock("job", 10)
+			for j := 0; j < 20; j++ {
+				ok, err := lock.TryLock()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !ok {
+					continue
+				}
+				acquired.Add(1)
+				if holders.Add(1) > 1 {
+					overlap.Store(true)
+				}
+				time.Sleep(time.Microsecond * 100)
+				holders.Add(-1)
+				if err := lock.Unlock(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if overlap.Load() || acquired.Load() == 0 {
+		t.Fatalf("overlap = %v, acquired = %d", overlap.Load(), acquired.Load())
+	}
+
+	if ok, err := mc.Lock("job", 0).TryLock(); ok || !errors.Is(err, ErrInval) {
+		t.Fatalf("TryLock with ttl 0 = %v, %v, want ErrInval", ok, err)
+	}
+} /*}}}*/
+
+//非持有者Unlock、Refresh返回ErrLockNotHeld，不会删除其它owner的锁
+func TestLockNotHeld(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	owner, other := mc.Lock("job", 10), mc.Lock("job", 10)
+	if err := other.Unlock(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Unlock without lock err = %v, want ErrLockNotHeld", err)
+	}
+	owner.TryLock()
+	if err := other.Unlock(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Unlock by non-owner err = %v, want ErrLockNotHeld", err)
+	}
+	if err := other.Refresh(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Refresh by non-owner err = %v, want ErrLockNotHeld", err)
+	}
+	if value, _, err := mc.Get("job"); err != nil || value != owner.token {
+		t.Fatalf("lock value = %v, %v, want owner token", value, err)
+	}
+
+	if err := owner.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	//已释放的锁不能再次释放
+	if err := owner.Unlock(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("second Unlock err = %v, want ErrLockNotHeld", err)
+	}
+} /*}}}*/
+
+//锁过期后被其它owner持有，原持有者Refresh、Unlock返回ErrLockNotHeld
+func TestLockRefreshExpired(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+
+	owner, other := mc.Lock("job", 10), mc.Lock("job", 10)
+	owner.TryLock()
+	fs[0].expire("job")
+	if err := owner.Refresh(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Refresh after expiry err = %v, want ErrLockNotHeld", err)
+	}
+
+	owner.TryLock()
+	fs[0].expire("job")
+	if ok, err := other.TryLock(); !ok || err != nil {
+		t.Fatalf("TryLock after expiry = %v, %v", ok, err)
+	}
+	if err := owner.Refresh(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Refresh after taken over err = %v, want ErrLockNotHeld", err)
+	}
+	if err := owner.Unlock(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Unlock after taken over err = %v, want ErrLockNotHeld", err)
+	}
+	if err := other.Refresh(); err != nil {
+		t.Fatalf("Refresh by new owner: %v", err)
+	}
+} /*}}}*/
+
+func TestLockContext(t *testing.T) { /*{{{*/
+	interval := lockPollInterval
+	lockPollInterval = time.Millisecond * 5
+	t.Cleanup(func() { lockPollInterval = interval })
+
+	mc, _ := newFakeClient(t, 1)
+	owner, waiter := mc.Lock("job", 10), mc.Lock("job", 10)
+	owner.TryLock()
+
+	//ctx取消时返回ctx.Err()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*30)
+	defer cancel()
+	if err := waiter.LockContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LockContext err = %v, want DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		cancel()
+	}()
+	if err := waiter.LockContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LockContext err = %v, want Canceled", err)
+	}
+
+	//持有者释放后加锁成功
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		owner.Unlock()
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := waiter.LockContext(ctx); err != nil {
+		t.Fatalf("LockContext after unlock: %v", err)
+	}
+	if err := waiter.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+} /*}}}*/
@@ -0,0 +1,88 @@
+package memcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (this LogLevel) String() string { /*{{{*/
+	switch this {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+} /*}}}*/
+
+//连接、集群事件的日志，args为交替的key、value，如"server", "127.0.0.1:11211", "err", err
+//log/slog可以使用子包github.com/pangudashu/memcache/slog适配
+type Logger interface {
+	Log(level LogLevel, msg string, args ...interface{})
+}
+
+//client、连接池、连接共用的日志输出，未设置Logger时不输出
+type eventLog struct {
+	logger        atomic.Value //loggerValue
+	slowThreshold atomic.Int64 //慢命令阈值，0表示不记录
+}
+
+//atomic.Value要求每次存储的类型一致
+type loggerValue struct {
+	Logger
+}
+
+func (this *eventLog) get() Logger { /*{{{*/
+	if this == nil {
+		return nil
+	}
+	v, _ := this.logger.Load().(loggerValue)
+	return v.Logger
+} /*}}}*/
+
+func (this *eventLog) log(level LogLevel, msg string, args ...interface{}) { /*{{{*/
+	if logger := this.get(); logger != nil {
+		logger.Log(level, msg, args...)
+	}
+} /*}}}*/
+
+//设置日志输出，nil表示不输出
+//第一次设置时输出创建Memcache时建立初始连接失败的server
+func (this *Memcache) SetLogger(logger Logger) { /*{{{*/
+	this.manager.Lock()
+	defer this.manager.Unlock()
+
+	this.log.logger.Store(loggerValue{logger})
+	if logger == nil {
+		return
+	}
+
+	for _, s := range this.manager.serverList {
+		if s.pool != nil && s.pool.initErr != nil {
+			this.log.log(LogWarn, "memcache: dial failed", "server", s.Address, "err", s.pool.initErr)
+			s.pool.initErr = nil
+		}
+	}
+} /*}}}*/
+
+//耗时(包括重试)达到threshold的命令输出WARN日志，0表示不记录，需要同时设置Logger
+func (this *Memcache) SetSlowThreshold(threshold time.Duration) { /*{{{*/
+	if threshold < 0 {
+		threshold = 0
+	}
+	this.log.slowThreshold.Store(int64(threshold))
+} /*}}}*/
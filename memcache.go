@@ -1,22 +1,51 @@
 package memcache
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Memcache struct {
-	nodes   *Nodes
-	manager *serverManager
+	*client
+	ctx context.Context //WithContext绑定的context，用于追踪
+	ns  *namespace      //Namespace设置的key前缀
+}
+
+//Memcache的共享状态，WithContext返回的Memcache共用同一个client
+type client struct {
+	nodes       *Nodes
+	manager     *serverManager
+	negativeTTL uint32 //GetOrLoad负缓存有效期，0表示不缓存
+
+	hooks  []Hook //命令执行完成时的回调
+	tracer Tracer //命令的追踪
+	log    *eventLog
+
+	writers       map[*Server]*batchWriter //异步请求的写协程
+	writersClosed bool                     //Close后不再创建写协程
+	writerLock    sync.Mutex
+	batchWindow   atomic.Int64 //请求合并等待时间
+	batchMaxCnt   atomic.Int64 //每批最大请求数，大于0时同步命令也合并发送
+
+	streamChunkSize int  //SetStream每个分块的大小
+	hashKeys        bool //不合法的key替换为sha256
 
-	sync.RWMutex //保证操作nodes的原子性
+	nodes_lock sync.RWMutex //保证操作nodes的原子性
 }
 
 type serverManager struct {
 	serverList      []*Server
 	badServerNotice chan bool
 	isRmBadServer   bool
+	stopRefresh     chan struct{} //Close时关闭，停止server列表的定时刷新
+	rebuildCnt      atomic.Uint64 //server状态变化导致hash环重建的次数
+
+	sync.Mutex //保证更新serverList的原子性
 }
 
 var (
@@ -31,27 +60,18 @@ func NewMemcache(server_list []*Server) (mem *Memcache, err error) { /*{{{*/
 		return nil, errors.New("Server is nil or address is empty")
 	}
 
-	mem = &Memcache{}
+	mem = &Memcache{client: &client{log: &eventLog{}}}
 
 	//create connect pool
 	for _, server := range server_list {
-		if server == nil || server.Address == "" {
-			return nil, errors.New("Server is nil or address is empty")
-		}
-		if server.MaxConn == 0 {
-			server.MaxConn = defaultMaxConn
-		}
-		if server.InitConn == 0 {
-			server.InitConn = defaultInitConn
-		}
-		if server.IdleTime == 0 {
-			server.IdleTime = defaultIdleTime
+		if err := initServer(server, mem.log); err != nil {
+			return nil, err
 		}
-		server.isActive = true
 	}
 
 	mem.manager = &serverManager{
-		serverList: server_list,
+		serverList:  server_list,
+		stopRefresh: make(chan struct{}),
 	}
 
 	//create server hash node
@@ -59,6 +79,115 @@ func NewMemcache(server_list []*Server) (mem *Memcache, err error) { /*{{{*/
 	return mem, nil
 } /*}}}*/
 
+//检查server配置并设置默认值
+func initServer(server *Server, log *eventLog) error { /*{{{*/
+	if server == nil || server.Address == "" {
+		return errors.New("Server is nil or address is empty")
+	}
+	if _, _, err := parseAddress(server.Address); err != nil {
+		return fmt.Errorf("%w: %s", err, server.Address)
+	}
+	if server.MaxConn == 0 {
+		server.MaxConn = defaultMaxConn
+	}
+	if server.InitConn == 0 {
+		server.InitConn = defaultInitConn
+	}
+	if server.IdleTime == 0 {
+		server.IdleTime = defaultIdleTime
+	}
+	server.isActive = true
+	server.log = log
+	return nil
+} /*}}}*/
+
+//更新server列表，Address相同的server保留原有连接池(Weight以新配置为准)，新增的server创建连接池
+//MaxConn、InitConn、IdleTime变化的server重新创建连接池
+//切换时等待正在执行的命令完成，移除的server及被替换的连接池在切换后关闭
+func (this *Memcache) SetServers(server_list []*Server) error { /*{{{*/
+	if len(server_list) == 0 {
+		return errors.New("Server is nil or address is empty")
+	}
+	for _, server := range server_list {
+		if server == nil || server.Address == "" {
+			return errors.New("Server is nil or address is empty")
+		}
+		if _, _, err := parseAddress(server.Address); err != nil {
+			return fmt.Errorf("%w: %s", err, server.Address)
+		}
+	}
+
+	this.manager.Lock()
+	defer this.manager.Unlock()
+
+	removed := make(map[string]*Server, len(this.manager.serverList))
+	for _, s := range this.manager.serverList {
+		removed[s.Address] = s
+	}
+
+	new_server_list := make([]*Server, 0, len(server_list))
+	active_list := make([]*Server, 0, len(server_list))
+	for _, server := range server_list {
+		s, ok := removed[server.Address]
+		if ok && samePoolConfig(s, server) {
+			s.Weight = server.Weight
+			delete(removed, server.Address)
+		} else {
+			//同一个列表中重复的address只保留第一个
+			if contains(new_server_list, server.Address) {
+				continue
+			}
+			initServer(server, this.log)
+			s = server
+		}
+		new_server_list = append(new_server_list, s)
+		if s.isActive {
+			active_list = append(active_list, s)
+		}
+	}
+
+	//新增server的连接池在createServerNode中创建，不阻塞正在执行的命令
+	new_nodes := createServerNode(active_list)
+
+	this.nodes_lock.Lock()
+	this.nodes = new_nodes
+	this.manager.serverList = new_server_list
+	this.nodes_lock.Unlock()
+
+	for _, s := range removed {
+		this.closeBatchWriter(s)
+		if s.pool != nil {
+			s.pool.Close()
+		}
+	}
+	this.log.log(LogInfo, "memcache: server list updated", "servers", len(new_server_list), "active", len(active_list), "removed", len(removed))
+	return nil
+} /*}}}*/
+
+//连接池配置是否一致，server未设置的值按默认值比较
+func samePoolConfig(s *Server, server *Server) bool { /*{{{*/
+	max_conn, init_conn, idle_time := server.MaxConn, server.InitConn, server.IdleTime
+	if max_conn == 0 {
+		max_conn = defaultMaxConn
+	}
+	if init_conn == 0 {
+		init_conn = defaultInitConn
+	}
+	if idle_time == 0 {
+		idle_time = defaultIdleTime
+	}
+	return s.MaxConn == max_conn && s.InitConn == init_conn && s.IdleTime == idle_time
+} /*}}}*/
+
+func contains(server_list []*Server, address string) bool { /*{{{*/
+	for _, s := range server_list {
+		if s.Address == address {
+			return true
+		}
+	}
+	return false
+} /*}}}*/
+
 //设置是否移除不可用server
 func (this *Memcache) SetRemoveBadServer(option bool) { /*{{{*/
 	if option == false {
@@ -71,31 +200,60 @@ func (this *Memcache) SetRemoveBadServer(option bool) { /*{{{*/
 } /*}}}*/
 
 func (this *Memcache) SetTimeout(dial, read, write time.Duration) { /*{{{*/
-	dialTimeout = dial
-	readTimeout = read
-	writeTimeout = write
+	dialTimeout.Store(int64(dial))
+	readTimeout.Store(int64(read))
+	writeTimeout.Store(int64(write))
 } /*}}}*/
 
 func (this *Memcache) Get(key string, format ...interface{}) (value interface{}, cas uint64, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	res, err := this.get(key, format...)
+
+	if res != nil {
+		return res.body, res.header.cas, err
+	} else {
+		return nil, 0, err
+	}
+} /*}}}*/
+
+func (this *Memcache) get(key string, format ...interface{}) (res *response, err error) { /*{{{*/
+	res, err = this.getValue(key, format...)
+	if err == nil && res.flags == VALUE_TYPE_TAGGED {
+		err = this.untag(key, res, format...)
+	}
+	return res, err
+} /*}}}*/
+
+func (this *Memcache) getValue(key string, format ...interface{}) (res *response, err error) { /*{{{*/
+	key, err = this.storageKey(OP_GET, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if this.batchMaxCnt.Load() > 0 {
+		return this.sendAsync(&asyncRequest{opcode: OP_GET, key: key, format: format}).wait()
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 
-	var res *response
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_GET, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return nil, 0, ErrNotConn
+		return nil, newOpError(OP_GET, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return nil, 0, e
+			return nil, newOpError(OP_GET, key, server, e)
 		}
 
 		res, err = conn.get(key, format...)
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -103,36 +261,156 @@ func (this *Memcache) Get(key string, format ...interface{}) (value interface{},
 		}
 	}
 
-	if res != nil {
-		return res.body, res.header.cas, err
-	} else {
-		return nil, 0, err
+	return res, err
+} /*}}}*/
+
+//读取key存储的原始字节，结果追加到buf[:0]，buf容量足够时不分配内存
+//不做类型转换，不经过请求合并，适合热点路径上复用buf读取
+func (this *Memcache) GetInto(key string, buf []byte) (value []byte, cas uint64, err error) { /*{{{*/
+	key, err = this.storageKey(OP_GET, key)
+	if err != nil {
+		return buf[:0], 0, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
+
+	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_GET, key, server)
+	defer this.finish(&call, &err)
+	if server == nil {
+		return buf[:0], 0, newOpError(OP_GET, key, nil, ErrNotConn)
+	}
+
+	for ; call.tries < badTryCnt; call.tries++ {
+		conn, e := server.pool.Get()
+		if e != nil {
+			this.sendBadServerNotice()
+			return buf[:0], 0, newOpError(OP_GET, key, server, e)
+		}
+
+		value, cas, err = conn.getInto(key, buf)
+		call.size = conn.valueLen
+
+		if connBroken(err) {
+			server.pool.Release(conn)
+		} else {
+			server.pool.Put(conn)
+			break
+		}
+	}
+
+	return value, cas, err
+} /*}}}*/
+
+//批量读取，返回命中的key => response，各server依次处理
+func (this *Memcache) getMulti(keys []string) (res map[string]*response, err error) { /*{{{*/
+	res, err = this.getMultiValue(keys)
+	if e := this.untagMulti(res); e != nil && err == nil {
+		err = e
+	}
+	return res, err
+} /*}}}*/
+
+func (this *Memcache) getMultiValue(keys []string) (res map[string]*response, err error) { /*{{{*/
+	if real_keys, key_errs := this.storageKeys(OP_GETKQ, keys); real_keys != nil {
+		send_keys, origin := originKeys(keys, real_keys)
+		res, err = this.root().getMultiValue(send_keys)
+		for _, key := range keys {
+			if e, ok := key_errs[key]; ok && err == nil {
+				err = e
+			}
+		}
+		return restoreKeys(res, origin), err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
+
+	span := this.begin(this.ctx, OP_GETKQ, "", nil)
+	defer this.finishSpan(&span, &err)
+
+	groups, err := this.nodes.groupByServer(keys)
+	if err != nil {
+		return nil, newOpError(OP_GETKQ, "", nil, err)
+	}
+
+	res = make(map[string]*response, len(keys))
+	for server, server_keys := range groups {
+		var server_res map[string]*response
+		var server_err error
+		call := this.begin(span.ctx, OP_GETKQ, "", server)
+
+		for ; call.tries < badTryCnt; call.tries++ {
+			conn, e := server.pool.Get()
+			if e != nil {
+				this.sendBadServerNotice()
+				server_err = newOpError(OP_GETKQ, "", server, e)
+				break
+			}
+
+			server_res, server_err = conn.getMulti(server_keys)
+
+			if connBroken(server_err) {
+				server.pool.Release(conn)
+			} else {
+				server.pool.Put(conn)
+				break
+			}
+		}
+
+		this.finish(&call, &server_err)
+
+		for k, v := range server_res {
+			res[k] = v
+		}
+		if server_err != nil && err == nil {
+			err = server_err
+		}
 	}
+
+	return res, err
 } /*}}}*/
 
 func (this *Memcache) Set(key string, value interface{}, expire ...uint32) (res bool, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	key, err = this.storageKey(OP_SET, key)
+	if err != nil {
+		return false, err
+	}
+
 	var timeout uint32 = 0
 
 	if len(expire) > 0 {
 		timeout = expire[0]
 	}
+	if err := checkExpire(timeout); err != nil {
+		return false, newOpError(OP_SET, key, nil, err)
+	}
+	if this.batchMaxCnt.Load() > 0 {
+		_, err = this.sendAsync(&asyncRequest{opcode: OP_SET, key: key, value: value, expire: timeout}).wait()
+		return err == nil, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_SET, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return false, ErrNotConn
+		return false, newOpError(OP_SET, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_SET, key, server, e)
 		}
 
 		res, err = conn.store(OP_SET, key, value, timeout, 0)
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -144,27 +422,43 @@ func (this *Memcache) Set(key string, value interface{}, expire ...uint32) (res
 } /*}}}*/
 
 func (this *Memcache) Add(key string, value interface{}, expire ...uint32) (res bool, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	key, err = this.storageKey(OP_ADD, key)
+	if err != nil {
+		return false, err
+	}
+
 	var timeout uint32 = 0
 
 	if len(expire) > 0 {
 		timeout = expire[0]
 	}
+	if err := checkExpire(timeout); err != nil {
+		return false, newOpError(OP_ADD, key, nil, err)
+	}
+	if this.batchMaxCnt.Load() > 0 {
+		_, err = this.sendAsync(&asyncRequest{opcode: OP_ADD, key: key, value: value, expire: timeout}).wait()
+		return err == nil, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_ADD, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return false, ErrNotConn
+		return false, newOpError(OP_ADD, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_ADD, key, server, e)
 		}
 
 		res, err = conn.store(OP_ADD, key, value, timeout, 0)
-		if err == ErrBadConn {
+		call.size = conn.valueLen
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -176,8 +470,11 @@ func (this *Memcache) Add(key string, value interface{}, expire ...uint32) (res
 } /*}}}*/
 
 func (this *Memcache) Replace(key string, value interface{}, args ...uint64) (res bool, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	key, err = this.storageKey(OP_REPLACE, key)
+	if err != nil {
+		return false, err
+	}
+
 	var timeout uint32 = 0
 	var cas uint64 = 0
 
@@ -188,20 +485,36 @@ func (this *Memcache) Replace(key string, value interface{}, args ...uint64) (re
 		timeout = uint32(args[0])
 		cas = args[1]
 	}
+	if len(args) > 0 && args[0] > math.MaxUint32 {
+		return false, newOpError(OP_REPLACE, key, nil, ErrInvalExpire)
+	}
+	if err := checkExpire(timeout); err != nil {
+		return false, newOpError(OP_REPLACE, key, nil, err)
+	}
+	if this.batchMaxCnt.Load() > 0 {
+		_, err = this.sendAsync(&asyncRequest{opcode: OP_REPLACE, key: key, value: value, expire: timeout, cas: cas}).wait()
+		return err == nil, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_REPLACE, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return false, ErrNotConn
+		return false, newOpError(OP_REPLACE, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_REPLACE, key, server, e)
 		}
 
 		res, err = conn.store(OP_REPLACE, key, value, timeout, cas)
-		if err == ErrBadConn {
+		call.size = conn.valueLen
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -212,23 +525,40 @@ func (this *Memcache) Replace(key string, value interface{}, args ...uint64) (re
 } /*}}}*/
 
 func (this *Memcache) Delete(key string, cas ...uint64) (res bool, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	key, err = this.storageKey(OP_DELETE, key)
+	if err != nil {
+		return false, err
+	}
+
+	if this.batchMaxCnt.Load() > 0 {
+		req := &asyncRequest{opcode: OP_DELETE, key: key}
+		if len(cas) > 0 {
+			req.cas = cas[0]
+		}
+		_, err = this.sendAsync(req).wait()
+		return err == nil, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_DELETE, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return false, ErrNotConn
+		return false, newOpError(OP_DELETE, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_DELETE, key, server, e)
 		}
 
 		res, err = conn.delete(key, cas...)
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -240,23 +570,31 @@ func (this *Memcache) Delete(key string, cas ...uint64) (res bool, err error) {
 } /*}}}*/
 
 func (this *Memcache) Increment(key string, args ...interface{}) (res bool, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	key, err = this.storageKey(OP_INCREMENT, key)
+	if err != nil {
+		return false, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_INCREMENT, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return false, ErrNotConn
+		return false, newOpError(OP_INCREMENT, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_INCREMENT, key, server, e)
 		}
 
 		res, err = conn.numberic(OP_INCREMENT, key, args...)
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -268,23 +606,31 @@ func (this *Memcache) Increment(key string, args ...interface{}) (res bool, err
 } /*}}}*/
 
 func (this *Memcache) Decrement(key string, args ...interface{}) (res bool, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	key, err = this.storageKey(OP_DECREMENT, key)
+	if err != nil {
+		return false, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_DECREMENT, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return false, ErrNotConn
+		return false, newOpError(OP_DECREMENT, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_DECREMENT, key, server, e)
 		}
 
 		res, err = conn.numberic(OP_DECREMENT, key, args...)
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -296,23 +642,31 @@ func (this *Memcache) Decrement(key string, args ...interface{}) (res bool, err
 } /*}}}*/
 
 func (this *Memcache) Append(key string, value string, cas ...uint64) (res bool, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	key, err = this.storageKey(OP_APPEND, key)
+	if err != nil {
+		return false, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_APPEND, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return false, ErrNotConn
+		return false, newOpError(OP_APPEND, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_APPEND, key, server, e)
 		}
 
 		res, err = conn.appends(OP_APPEND, key, value, cas...)
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -324,23 +678,31 @@ func (this *Memcache) Append(key string, value string, cas ...uint64) (res bool,
 } /*}}}*/
 
 func (this *Memcache) Prepend(key string, value string, cas ...uint64) (res bool, err error) { /*{{{*/
-	this.RLock()
-	defer this.RUnlock()
+	key, err = this.storageKey(OP_PREPEND, key)
+	if err != nil {
+		return false, err
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
 	server := this.nodes.getServerByKey(key)
+	call := this.begin(this.ctx, OP_PREPEND, key, server)
+	defer this.finish(&call, &err)
 	if server == nil {
-		return false, ErrNotConn
+		return false, newOpError(OP_PREPEND, key, nil, ErrNotConn)
 	}
 
-	for i := 0; i < badTryCnt; i++ {
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_PREPEND, key, server, e)
 		}
 
 		res, err = conn.appends(OP_PREPEND, key, value, cas...)
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -352,16 +714,20 @@ func (this *Memcache) Prepend(key string, value string, cas ...uint64) (res bool
 } /*}}}*/
 
 func (this *Memcache) Flush(server *Server, delay ...uint32) (res bool, err error) { /*{{{*/
-	for i := 0; i < badTryCnt; i++ {
+	call := this.begin(this.ctx, OP_FLUSH, "", server)
+	defer this.finish(&call, &err)
+
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return false, e
+			return false, newOpError(OP_FLUSH, "", server, e)
 		}
 
 		res, err = conn.flush(delay...)
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -372,16 +738,20 @@ func (this *Memcache) Flush(server *Server, delay ...uint32) (res bool, err erro
 } /*}}}*/
 
 func (this *Memcache) Version(server *Server) (v string, err error) { /*{{{*/
-	for i := 0; i < badTryCnt; i++ {
+	call := this.begin(this.ctx, OP_VERSION, "", server)
+	defer this.finish(&call, &err)
+
+	for ; call.tries < badTryCnt; call.tries++ {
 		conn, e := server.pool.Get()
-		if e != nil && e == ErrNotConn {
+		if e != nil {
 			this.sendBadServerNotice()
-			return "", e
+			return "", newOpError(OP_VERSION, "", server, e)
 		}
 
 		v, err = conn.version()
+		call.size = conn.valueLen
 
-		if err == ErrBadConn {
+		if connBroken(err) {
 			server.pool.Release(conn)
 		} else {
 			server.pool.Put(conn)
@@ -392,6 +762,15 @@ func (this *Memcache) Version(server *Server) (v string, err error) { /*{{{*/
 	return v, err
 } /*}}}*/
 
+//连接server失败、没有可用server等错误，server为nil表示key没有分配到server
+func newOpError(opcode opcode_t, key string, server *Server, err error) error { /*{{{*/
+	op_err := &OpError{Op: opcode, Key: key, Err: err}
+	if server != nil {
+		op_err.Server = server.Address
+	}
+	return op_err
+} /*}}}*/
+
 func (this *Memcache) sendBadServerNotice() { /*{{{*/
 	if this.manager.isRmBadServer == false {
 		return
@@ -415,6 +794,9 @@ func (this *Memcache) monitorBadServer() { /*{{{*/
 } /*}}}*/
 
 func (this *Memcache) doDealBadServer() { /*{{{*/
+	this.manager.Lock()
+	defer this.manager.Unlock()
+
 	var res map[*Server]chan bool
 	var isReload bool = false
 
@@ -431,11 +813,13 @@ func (this *Memcache) doDealBadServer() { /*{{{*/
 				s.pool.Close()
 				s.pool = nil
 				isReload = true
+				this.log.log(LogInfo, "memcache: server recovered", "server", s.Address)
 			}
 			s.isActive = true
 		case false:
 			if s.isActive == true {
 				isReload = true
+				this.log.log(LogWarn, "memcache: server unavailable, removed from hash ring", "server", s.Address)
 			}
 			s.isActive = false
 		}
@@ -454,15 +838,17 @@ func (this *Memcache) doDealBadServer() { /*{{{*/
 	}
 	//create server hash node
 	new_nodes := createServerNode(new_server_list)
+	this.manager.rebuildCnt.Add(1)
+	this.log.log(LogInfo, "memcache: hash ring rebuilt", "active", len(new_server_list), "servers", len(this.manager.serverList))
 
-	this.Lock()
+	this.nodes_lock.Lock()
 	this.nodes = new_nodes
-	this.Unlock()
+	this.nodes_lock.Unlock()
 } /*}}}*/
 
 func (this *Memcache) checkServerActive(server *Server, ch chan bool) { /*{{{*/
 	conn, e := server.pool.Get()
-	if e != nil && e == ErrNotConn {
+	if e != nil {
 		//can't connect to server
 		ch <- false
 		return
@@ -470,7 +856,7 @@ func (this *Memcache) checkServerActive(server *Server, ch chan bool) { /*{{{*/
 
 	res, err := conn.noop()
 
-	if err == ErrBadConn {
+	if connBroken(err) {
 		server.pool.Release(conn)
 	} else {
 		server.pool.Put(conn)
@@ -482,7 +868,7 @@ func (this *Memcache) checkServerActive(server *Server, ch chan bool) { /*{{{*/
 	}
 
 	//noop failed ,then try dial server
-	if new_connection, err := connect(server.Address); err == ErrNotConn {
+	if new_connection, err := connect(server.Address, server.Dialer, server.TLSConfig); err != nil {
 		ch <- false
 	} else {
 		ch <- true
@@ -491,6 +877,17 @@ func (this *Memcache) checkServerActive(server *Server, ch chan bool) { /*{{{*/
 } /*}}}*/
 
 func (this *Memcache) Close() {
+	this.closeBatchWriters()
+
+	this.manager.Lock()
+	defer this.manager.Unlock()
+
+	select {
+	case <-this.manager.stopRefresh:
+	default:
+		close(this.manager.stopRefresh)
+	}
+
 	for _, s := range this.manager.serverList {
 		s.pool.Close()
 	}
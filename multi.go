@@ -0,0 +1,182 @@
+package memcache
+
+//批量写入，按server分组后使用SETQ一次发送，只返回写入失败的key => error，全部成功时返回nil
+func (this *Memcache) SetMulti(items []*Item) (errs map[string]error) { /*{{{*/
+	return this.storeMulti(OP_SETQ, items)
+} /*}}}*/
+
+//批量Add，使用ADDQ，返回值同SetMulti
+func (this *Memcache) AddMulti(items []*Item) (errs map[string]error) { /*{{{*/
+	return this.storeMulti(OP_ADDQ, items)
+} /*}}}*/
+
+//批量Replace，使用REPLACEQ，返回值同SetMulti
+func (this *Memcache) ReplaceMulti(items []*Item) (errs map[string]error) { /*{{{*/
+	return this.storeMulti(OP_REPLACEQ, items)
+} /*}}}*/
+
+//批量删除，使用DELETEQ，只返回删除失败的key => error(如ErrNotFound)，全部成功时返回nil
+func (this *Memcache) DeleteMulti(keys []string) (errs map[string]error) { /*{{{*/
+	if real_keys, key_errs := this.storageKeys(OP_DELETEQ, keys); real_keys != nil {
+		send_keys, origin := originKeys(keys, real_keys)
+		errs = restoreKeys(this.root().DeleteMulti(send_keys), origin)
+		return mergeKeyErrors(errs, key_errs)
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
+
+	errs = make(map[string]error)
+
+	span := this.begin(this.ctx, OP_DELETEQ, "", nil)
+	defer this.finishMultiSpan(&span, errs)
+
+	groups := make(map[*Server][]string)
+	for _, key := range keys {
+		server := this.nodes.getServerByKey(key)
+		if server == nil {
+			errs[key] = newOpError(OP_DELETEQ, key, nil, ErrNotConn)
+			continue
+		}
+		groups[server] = append(groups[server], key)
+	}
+
+	for server, server_keys := range groups {
+		var server_errs map[string]error
+		var err error
+		call := this.begin(span.ctx, OP_DELETEQ, "", server)
+
+		//已执行的删除重试时会返回ErrNotFound，连接出错时不重试
+		if conn, e := server.pool.Get(); e != nil {
+			this.sendBadServerNotice()
+			err = newOpError(OP_DELETEQ, "", server, e)
+		} else {
+			server_errs, err = conn.deleteMulti(server_keys)
+			if connBroken(err) {
+				server.pool.Release(conn)
+			} else {
+				server.pool.Put(conn)
+			}
+		}
+
+		this.finish(&call, &err)
+		mergeMultiErrors(errs, server_errs, server_keys, err)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+} /*}}}*/
+
+func (this *Memcache) storeMulti(opcode opcode_t, items []*Item) (errs map[string]error) { /*{{{*/
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+	if real_keys, key_errs := this.storageKeys(opcode, keys); real_keys != nil {
+		send_items := make([]*Item, 0, len(items))
+		origin := make(map[string]string, len(items))
+		for i, item := range items {
+			if real_keys[i] == "" {
+				continue
+			}
+			real_item := *item
+			real_item.Key = real_keys[i]
+			send_items = append(send_items, &real_item)
+			origin[real_keys[i]] = item.Key
+		}
+		errs = restoreKeys(this.root().storeMulti(opcode, send_items), origin)
+		return mergeKeyErrors(errs, key_errs)
+	}
+
+	this.nodes_lock.RLock()
+	defer this.nodes_lock.RUnlock()
+
+	errs = make(map[string]error)
+
+	span := this.begin(this.ctx, opcode, "", nil)
+	defer this.finishMultiSpan(&span, errs)
+
+	groups := make(map[*Server][]*Item)
+	for _, item := range items {
+		if err := checkExpire(item.Expiration); err != nil {
+			errs[item.Key] = newOpError(opcode, item.Key, nil, err)
+			continue
+		}
+		server := this.nodes.getServerByKey(item.Key)
+		if server == nil {
+			errs[item.Key] = newOpError(opcode, item.Key, nil, ErrNotConn)
+			continue
+		}
+		groups[server] = append(groups[server], item)
+	}
+
+	for server, server_items := range groups {
+		var server_errs map[string]error
+		var err error
+		call := this.begin(span.ctx, opcode, "", server)
+		retry := retryableMulti(opcode, server_items)
+
+		for ; call.tries < badTryCnt; call.tries++ {
+			conn, e := server.pool.Get()
+			if e != nil {
+				this.sendBadServerNotice()
+				err = newOpError(opcode, "", server, e)
+				break
+			}
+
+			server_errs, err = conn.storeMulti(opcode, server_items)
+			if !connBroken(err) {
+				server.pool.Put(conn)
+				break
+			}
+
+			//连接不可用时只有可以重复执行的命令使用新连接重试
+			server.pool.Release(conn)
+			if !retry {
+				break
+			}
+		}
+
+		this.finish(&call, &err)
+
+		server_keys := make([]string, len(server_items))
+		for i, item := range server_items {
+			server_keys[i] = item.Key
+		}
+		mergeMultiErrors(errs, server_errs, server_keys, err)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+} /*}}}*/
+
+//quiet命令成功时没有响应，连接出错时无法确定哪些命令已经执行，只有重复执行结果不变的才能重试
+//不带cas的SETQ可以重试；ADDQ、REPLACEQ及带cas的命令已执行的部分重试时会返回ErrKeyExists、ErrNotFound
+func retryableMulti(opcode opcode_t, items []*Item) bool { /*{{{*/
+	if opcode != OP_SETQ {
+		return false
+	}
+	for _, item := range items {
+		if item.CAS != 0 {
+			return false
+		}
+	}
+	return true
+} /*}}}*/
+
+//合并单个server的结果，连接出错时无法确定哪些key已处理，该server的key都记为err
+func mergeMultiErrors(errs map[string]error, server_errs map[string]error, keys []string, err error) { /*{{{*/
+	if err != nil {
+		for _, key := range keys {
+			errs[key] = err
+		}
+		return
+	}
+	for key, e := range server_errs {
+		errs[key] = e
+	}
+} /*}}}*/
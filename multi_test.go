@@ -0,0 +1,56 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+)
+
+//批量命令已执行但NOOP的响应丢失，只有不带cas的SETQ重试
+func TestMultiConnectionDropped(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+	f := fs[0]
+	keys := []string{"key_1", "key_2", "key_3"}
+	items := func() []*Item {
+		var items []*Item
+		for _, key := range keys {
+			items = append(items, &Item{Key: key, Value: "value"})
+		}
+		return items
+	}
+
+	f.mu.Lock()
+	f.dropNoop = 1
+	f.mu.Unlock()
+	if errs := mc.SetMulti(items()); errs != nil {
+		t.Fatalf("SetMulti errs = %v, want retried", errs)
+	}
+
+	//已经写入的key不能报告为ErrKeyExists、ErrNotFound
+	for _, tt := range []struct {
+		name string
+		run  func() map[string]error
+	}{
+		{"DeleteMulti", func() map[string]error { return mc.DeleteMulti(keys) }},
+		{"AddMulti", func() map[string]error { return mc.AddMulti(items()) }},
+		{"ReplaceMulti", func() map[string]error { return mc.ReplaceMulti(items()) }},
+	} {
+		f.mu.Lock()
+		f.dropNoop = 1
+		ops := f.ops
+		f.mu.Unlock()
+
+		errs := tt.run()
+		for _, key := range keys {
+			if !errors.Is(errs[key], ErrBadConn) {
+				t.Errorf("%s: errs[%s] = %v, want ErrBadConn", tt.name, key, errs[key])
+			}
+		}
+
+		f.mu.Lock()
+		sent := f.ops - ops
+		f.mu.Unlock()
+		if sent != len(keys)+1 {
+			t.Errorf("%s: server received %d requests, want %d without retry", tt.name, sent, len(keys)+1)
+		}
+	}
+} /*}}}*/
@@ -0,0 +1,105 @@
+package memcache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//namespace的版本号在本地缓存的时间，其它client调用InvalidateNamespace后最多延迟该时间生效
+var namespaceRefreshInterval = time.Second
+
+type namespace struct {
+	prefix string
+	genKey string //存储版本号的key
+
+	gen    int
+	expire time.Time
+	sync.Mutex
+}
+
+//返回key自动加上前缀的Memcache，与原Memcache共用连接池、配置，实际存储的key为prefix:版本号:key
+//版本号存储在memcached中，InvalidateNamespace后版本号变化，原有的key不再被访问，等待过期或被淘汰
+//在Namespace返回的Memcache上调用时替换原有的namespace
+func (this *Memcache) Namespace(prefix string) *Memcache { /*{{{*/
+	return &Memcache{
+		client: this.client,
+		ctx:    this.ctx,
+		ns:     &namespace{prefix: prefix, genKey: "ns:" + prefix},
+	}
+} /*}}}*/
+
+//使namespace下的所有key失效，只影响当前namespace，不需要Flush整个server
+//不是Namespace返回的Memcache时返回ErrInval
+func (this *Memcache) InvalidateNamespace() error { /*{{{*/
+	if this.ns == nil {
+		return ErrInval
+	}
+
+	//版本号被淘汰时不能由incr从0创建，否则会重新使用旧版本号，改为以当前时间重新创建
+	root := this.root()
+	_, err := root.IncrementBy(this.ns.genKey, 1, 0, CounterNoCreate)
+	if errors.Is(err, ErrNotFound) {
+		_, err = root.createCounter(this.ns.genKey)
+	}
+
+	this.ns.Lock()
+	this.ns.expire = time.Time{}
+	this.ns.Unlock()
+	return err
+} /*}}}*/
+
+//不带namespace的Memcache，用于读写版本号
+func (this *Memcache) root() *Memcache { /*{{{*/
+	return &Memcache{client: this.client, ctx: this.ctx}
+} /*}}}*/
+
+//返回实际存储的key，没有namespace时原样返回
+func (this *Memcache) namespaceKey(key string) (string, error) { /*{{{*/
+	if this.ns == nil {
+		return key, nil
+	}
+
+	gen, err := this.ns.generation(this.root())
+	if err != nil {
+		return key, err
+	}
+	return this.ns.prefix + ":" + strconv.Itoa(gen) + ":" + key, nil
+} /*}}}*/
+
+//读取版本号，不存在时创建
+func (this *namespace) generation(mc *Memcache) (gen int, err error) { /*{{{*/
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now()
+	if now.Before(this.expire) {
+		return this.gen, nil
+	}
+
+	value, _, err := mc.Get(this.genKey)
+	if errors.Is(err, ErrNotFound) {
+		value, err = mc.createCounter(this.genKey)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	gen, ok := value.(int)
+	if !ok {
+		return 0, newOpError(OP_GET, this.genKey, nil, ErrInvalFormat)
+	}
+	this.gen, this.expire = gen, now.Add(namespaceRefreshInterval)
+	return gen, nil
+} /*}}}*/
+
+//写入计数器的初始值，以当前时间作为初始值，避免计数器被淘汰后重新使用旧值
+//其它client已经写入时返回其写入的值
+func (this *Memcache) createCounter(key string) (value interface{}, err error) { /*{{{*/
+	value = int(time.Now().UnixNano())
+	if _, err = this.Add(key, value); errors.Is(err, ErrKeyExists) {
+		value, _, err = this.Get(key)
+	}
+	return value, err
+} /*}}}*/
@@ -0,0 +1,114 @@
+package memcache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+//返回namespace当前使用的版本号
+func nsGen(t *testing.T, mc *Memcache, key string) int { /*{{{*/
+	t.Helper()
+	value, _, err := mc.Get(key)
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	gen, ok := value.(int)
+	if !ok {
+		t.Fatalf("Get %s = %v, want int", key, value)
+	}
+	return gen
+} /*}}}*/
+
+func TestNamespaceKey(t *testing.T) { /*{{{*/
+	mc, fs := newFakeClient(t, 1)
+	app, other := mc.Namespace("app"), mc.Namespace("other")
+
+	if _, err := app.Set("key", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Set("key", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	//实际存储的key为prefix:版本号:key
+	gen := nsGen(t, mc, "ns:app")
+	real_key := "app:" + strconv.Itoa(gen) + ":key"
+	fs[0].mu.Lock()
+	item := fs[0].items[real_key]
+	fs[0].mu.Unlock()
+	if item == nil {
+		t.Fatalf("%s not stored", real_key)
+	}
+
+	if value, _, err := app.Get("key"); err != nil || value != "app" {
+		t.Errorf("app Get = %v, %v", value, err)
+	}
+	if value, _, err := other.Get("key"); err != nil || value != "other" {
+		t.Errorf("other Get = %v, %v", value, err)
+	}
+	if _, _, err := mc.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("root Get err = %v, want ErrNotFound", err)
+	}
+
+	if err := mc.InvalidateNamespace(); !errors.Is(err, ErrInval) {
+		t.Errorf("InvalidateNamespace without namespace err = %v, want ErrInval", err)
+	}
+} /*}}}*/
+
+func TestInvalidateNamespace(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+	app, other := mc.Namespace("app"), mc.Namespace("other")
+
+	if _, err := app.Set("key", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Set("key", "other"); err != nil {
+		t.Fatal(err)
+	}
+	gen := nsGen(t, mc, "ns:app")
+
+	if err := app.InvalidateNamespace(); err != nil {
+		t.Fatal(err)
+	}
+	if got := nsGen(t, mc, "ns:app"); got != gen+1 {
+		t.Errorf("generation = %d, want %d", got, gen+1)
+	}
+	if _, _, err := app.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after invalidate err = %v, want ErrNotFound", err)
+	}
+	//只影响当前namespace
+	if value, _, err := other.Get("key"); err != nil || value != "other" {
+		t.Errorf("other Get = %v, %v", value, err)
+	}
+} /*}}}*/
+
+//版本号被淘汰后不能从0重新开始，否则prefix:0:开头的旧数据重新可见
+func TestInvalidateNamespaceEvicted(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+	app := mc.Namespace("app")
+
+	if _, err := app.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	gen := nsGen(t, mc, "ns:app")
+	//其它client在版本号为0时写入的数据
+	if _, err := mc.Set("app:0:key", "stale"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mc.Delete("ns:app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.InvalidateNamespace(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := nsGen(t, mc, "ns:app")
+	if got == 0 || got == gen {
+		t.Fatalf("generation = %d after eviction, want a new one", got)
+	}
+	if _, _, err := app.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after evicted invalidate err = %v, want ErrNotFound", err)
+	}
+} /*}}}*/
@@ -0,0 +1,125 @@
+//OpenTelemetry追踪，单独的包避免memcache依赖OpenTelemetry
+//
+//	mc, _ := memcache.NewMemcache(servers)
+//	mc.SetTracer(memotel.NewTracer())
+//	value, _, err := mc.WithContext(ctx).Get("key")
+package otel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/pangudashu/memcache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/pangudashu/memcache/otel"
+
+type Tracer struct {
+	tracer  trace.Tracer
+	keyMode KeyMode
+}
+
+//span中key的记录方式
+type KeyMode int
+
+const (
+	KeyRaw    KeyMode = iota //原样记录
+	KeyHashed                //记录sha256的前16个十六进制字符，避免key中的用户信息泄露
+	KeyOmit                  //不记录
+)
+
+type Option func(*Tracer)
+
+//使用指定的TracerProvider，默认为otel.GetTracerProvider()
+func WithTracerProvider(provider trace.TracerProvider) Option { /*{{{*/
+	return func(this *Tracer) {
+		if provider != nil {
+			this.tracer = provider.Tracer(instrumentationName)
+		}
+	}
+} /*}}}*/
+
+//设置key的记录方式，默认KeyRaw
+func WithKeyMode(mode KeyMode) Option { /*{{{*/
+	return func(this *Tracer) {
+		this.keyMode = mode
+	}
+} /*}}}*/
+
+func NewTracer(opts ...Option) *Tracer { /*{{{*/
+	t := &Tracer{}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.tracer == nil {
+		t.tracer = otel.GetTracerProvider().Tracer(instrumentationName)
+	}
+	return t
+} /*}}}*/
+
+func (this *Tracer) Start(ctx context.Context, op string, key string) context.Context { /*{{{*/
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "memcached"),
+		attribute.String("db.operation", op),
+	}
+	if key != "" {
+		switch this.keyMode {
+		case KeyRaw:
+			attrs = append(attrs, attribute.String("db.memcached.key", key))
+		case KeyHashed:
+			sum := sha256.Sum256([]byte(key))
+			attrs = append(attrs, attribute.String("db.memcached.key_hash", hex.EncodeToString(sum[:8])))
+		}
+	}
+
+	ctx, _ = this.tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx
+} /*}}}*/
+
+func (this *Tracer) End(ctx context.Context, event *memcache.OpEvent) { /*{{{*/
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		span.End()
+		return
+	}
+
+	if event.Server != "" {
+		if host, port, err := net.SplitHostPort(event.Server); err == nil {
+			span.SetAttributes(attribute.String("server.address", host))
+			if p, err := strconv.Atoi(port); err == nil {
+				span.SetAttributes(attribute.Int("server.port", p))
+			}
+		} else {
+			span.SetAttributes(attribute.String("server.address", event.Server))
+		}
+	}
+	if event.ValueSize > 0 {
+		span.SetAttributes(attribute.Int("db.memcached.value_size", event.ValueSize))
+	}
+	if event.Retries > 0 {
+		span.SetAttributes(attribute.Int("db.memcached.retries", event.Retries))
+	}
+
+	//未命中、cas冲突等属于正常的业务结果，不标记为错误
+	if event.Err != nil {
+		if isMiss(event.Err) {
+			span.SetAttributes(attribute.String("db.memcached.status", event.Err.Error()))
+		} else {
+			span.RecordError(event.Err)
+			span.SetStatus(codes.Error, event.Err.Error())
+		}
+	}
+	span.End()
+} /*}}}*/
+
+func isMiss(err error) bool { /*{{{*/
+	return errors.Is(err, memcache.ErrNotFound) || errors.Is(err, memcache.ErrKeyExists) || errors.Is(err, memcache.ErrNotStord)
+} /*}}}*/
@@ -1,40 +1,69 @@
 package memcache
 
 import (
+	"crypto/tls"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+//连接池耗尽日志的最小间隔
+var poolWarnInterval = time.Second * 10
+
 //连接池
 type ConnectionPool struct {
 	pool     chan *Connection
 	address  string
 	maxCnt   int
-	totalCnt int
+	totalCnt atomic.Int64  //修改时同时持有锁，读取统计时不需要加锁
+	waitCnt  atomic.Uint64 //连接数达到maxCnt时等待空闲连接的次数
 	idleTime time.Duration
 
+	dialer    DialFunc    //非nil时使用dialer建立连接
+	tlsConfig *tls.Config //非nil时使用TLS连接
+
+	log      *eventLog
+	initErr  error        //未设置Logger时建立初始连接的错误，设置Logger时输出
+	warnTime atomic.Int64 //上次输出连接池耗尽日志的时间(UnixNano)
+
 	sync.Mutex
 }
 
-func open(address string, maxCnt int, initCnt int, idelTime time.Duration) (pool *ConnectionPool) {
+func open(address string, maxCnt int, initCnt int, idelTime time.Duration, dialer DialFunc, tlsConfig *tls.Config, log *eventLog) (pool *ConnectionPool) {
 	pool = &ConnectionPool{
-		pool:     make(chan *Connection, maxCnt),
-		address:  address,
-		maxCnt:   maxCnt,
-		idleTime: idelTime,
+		pool:      make(chan *Connection, maxCnt),
+		address:   address,
+		maxCnt:    maxCnt,
+		idleTime:  idelTime,
+		dialer:    dialer,
+		tlsConfig: tlsConfig,
+		log:       log,
 	}
 
 	for i := 0; i < initCnt; i++ {
-		conn, err := connect(address)
+		conn, err := pool.connect()
 		if err != nil {
+			if log.get() == nil {
+				pool.initErr = err
+			}
 			continue
 		}
-		pool.totalCnt++
+		pool.totalCnt.Add(1)
 		pool.pool <- conn
 	}
 	return pool
 }
 
+func (this *ConnectionPool) connect() (conn *Connection, err error) {
+	conn, err = connect(this.address, this.dialer, this.tlsConfig)
+	if err != nil {
+		this.log.log(LogError, "memcache: dial failed", "server", this.address, "err", err)
+		return nil, err
+	}
+	conn.log = this.log
+	return conn, nil
+}
+
 func (this *ConnectionPool) Get() (conn *Connection, err error) {
 	for {
 		conn, err = this.get()
@@ -60,25 +89,41 @@ func (this *ConnectionPool) get() (conn *Connection, err error) {
 	default:
 	}
 
+	//日志在加锁前输出，等待期间持有锁
+	if this.totalCnt.Load() >= int64(this.maxCnt) {
+		this.warnExhausted()
+	}
+
 	this.Lock()
 	defer this.Unlock()
 
-	if this.totalCnt >= this.maxCnt {
+	if this.totalCnt.Load() >= int64(this.maxCnt) {
 		//阻塞，直到有可用连接
+		this.waitCnt.Add(1)
 		conn = <-this.pool
 		return conn, nil
 	}
 
 	//create new connect
-	conn, err = connect(this.address)
+	conn, err = this.connect()
 	if err != nil {
 		return nil, err
 	}
-	this.totalCnt++
+	this.totalCnt.Add(1)
 
 	return conn, nil
 }
 
+//连接池耗尽时每个poolWarnInterval最多输出一次WARN，wait为累计等待次数
+func (this *ConnectionPool) warnExhausted() {
+	now := time.Now().UnixNano()
+	last := this.warnTime.Load()
+	if now-last < int64(poolWarnInterval) || !this.warnTime.CompareAndSwap(last, now) {
+		return
+	}
+	this.log.log(LogWarn, "memcache: connection pool exhausted", "server", this.address, "max_conn", this.maxCnt, "wait", this.waitCnt.Load())
+}
+
 func (this *ConnectionPool) Put(conn *Connection) {
 	if conn == nil {
 		return
@@ -90,10 +135,15 @@ func (this *ConnectionPool) Put(conn *Connection) {
 func (this *ConnectionPool) Release(conn *Connection) {
 	conn.Close()
 	this.Lock()
-	this.totalCnt--
+	this.totalCnt.Add(-1)
 	this.Unlock()
 }
 
+//返回已建立的连接数、空闲连接数、等待空闲连接的次数
+func (this *ConnectionPool) stats() (total int, idle int, wait uint64) {
+	return int(this.totalCnt.Load()), len(this.pool), this.waitCnt.Load()
+}
+
 //clear pool
 func (this *ConnectionPool) Close() {
 	for i := 0; i < len(this.pool); i++ {
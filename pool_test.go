@@ -0,0 +1,72 @@
+package memcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (this *recordLogger) Log(level LogLevel, msg string, args ...interface{}) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.msgs = append(this.msgs, level.String()+" "+msg)
+} /*}}}*/
+
+func (this *recordLogger) count(msg string) (n int) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, m := range this.msgs {
+		if m == msg {
+			n++
+		}
+	}
+	return n
+} /*}}}*/
+
+//连接池耗尽时等待空闲连接，每个poolWarnInterval最多输出一次日志
+func TestPoolExhaustedWarn(t *testing.T) { /*{{{*/
+	f := newFake(t)
+	logger := &recordLogger{}
+	log := &eventLog{}
+	log.logger.Store(loggerValue{logger})
+	pool := open(f.addr(), 1, 0, time.Minute, nil, nil, log)
+	defer pool.Close()
+
+	const msg = "WARN memcache: connection pool exhausted"
+	wait := func() {
+		conn, err := pool.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan *Connection)
+		go func() {
+			c, _ := pool.Get()
+			done <- c
+		}()
+		time.Sleep(time.Millisecond * 20)
+		pool.Put(conn)
+		pool.Put(<-done)
+	}
+
+	for i := 0; i < 3; i++ {
+		wait()
+	}
+	if _, _, waits := pool.stats(); waits != 3 {
+		t.Errorf("wait count = %d, want 3", waits)
+	}
+	if n := logger.count(msg); n != 1 {
+		t.Errorf("%d exhausted warnings, want 1", n)
+	}
+
+	//超过间隔后再次输出
+	pool.warnTime.Add(-int64(poolWarnInterval))
+	wait()
+	if n := logger.count(msg); n != 2 {
+		t.Errorf("%d exhausted warnings after interval, want 2", n)
+	}
+} /*}}}*/
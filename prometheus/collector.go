@@ -0,0 +1,122 @@
+//Prometheus统计，单独的包避免memcache依赖prometheus
+//
+//	mc, _ := memcache.NewMemcache(servers)
+//	prometheus.MustRegister(memprom.NewCollector(mc, "myapp"))
+package prometheus
+
+import (
+	"errors"
+
+	"github.com/pangudashu/memcache"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+type Collector struct {
+	mc *memcache.Memcache
+
+	requests *prom.CounterVec
+	latency  *prom.HistogramVec
+
+	poolConns    *prom.Desc
+	poolWaits    *prom.Desc
+	serverActive *prom.Desc
+	ringRebuilds *prom.Desc
+}
+
+//命令耗时的histogram分桶(秒)
+var DefaultBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+//创建collector并添加到mc的hook，namespace为指标名前缀，可以为空
+func NewCollector(mc *memcache.Memcache, namespace string) *Collector { /*{{{*/
+	c := &Collector{
+		mc: mc,
+		requests: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "memcache",
+			Name:      "requests_total",
+			Help:      "Number of memcache commands by opcode, status and server.",
+		}, []string{"op", "status", "server"}),
+		latency: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "memcache",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of memcache commands including retries.",
+			Buckets:   DefaultBuckets,
+		}, []string{"op", "server"}),
+		poolConns: prom.NewDesc(
+			prom.BuildFQName(namespace, "memcache", "pool_connections"),
+			"Connections in the pool by state (total, idle).",
+			[]string{"server", "state"}, nil),
+		poolWaits: prom.NewDesc(
+			prom.BuildFQName(namespace, "memcache", "pool_waits_total"),
+			"Number of times a command waited for an idle connection because the pool was full.",
+			[]string{"server"}, nil),
+		serverActive: prom.NewDesc(
+			prom.BuildFQName(namespace, "memcache", "server_active"),
+			"Whether the server is in the hash ring (1) or removed as bad (0).",
+			[]string{"server"}, nil),
+		ringRebuilds: prom.NewDesc(
+			prom.BuildFQName(namespace, "memcache", "ring_rebuilds_total"),
+			"Number of hash ring rebuilds caused by bad server changes.",
+			nil, nil),
+	}
+
+	mc.AddHook(c.observe)
+	return c
+} /*}}}*/
+
+func (this *Collector) Describe(ch chan<- *prom.Desc) { /*{{{*/
+	this.requests.Describe(ch)
+	this.latency.Describe(ch)
+	ch <- this.poolConns
+	ch <- this.poolWaits
+	ch <- this.serverActive
+	ch <- this.ringRebuilds
+} /*}}}*/
+
+func (this *Collector) Collect(ch chan<- prom.Metric) { /*{{{*/
+	this.requests.Collect(ch)
+	this.latency.Collect(ch)
+
+	stats := this.mc.Stats()
+	for _, s := range stats.Servers {
+		active := 0.0
+		if s.Active {
+			active = 1
+		}
+		ch <- prom.MustNewConstMetric(this.poolConns, prom.GaugeValue, float64(s.TotalConn), s.Address, "total")
+		ch <- prom.MustNewConstMetric(this.poolConns, prom.GaugeValue, float64(s.IdleConn), s.Address, "idle")
+		ch <- prom.MustNewConstMetric(this.poolWaits, prom.CounterValue, float64(s.WaitCount), s.Address)
+		ch <- prom.MustNewConstMetric(this.serverActive, prom.GaugeValue, active, s.Address)
+	}
+	ch <- prom.MustNewConstMetric(this.ringRebuilds, prom.CounterValue, float64(stats.RingRebuilds))
+} /*}}}*/
+
+func (this *Collector) observe(event *memcache.OpEvent) { /*{{{*/
+	this.requests.WithLabelValues(event.Op, Status(event.Err), event.Server).Inc()
+	this.latency.WithLabelValues(event.Op, event.Server).Observe(event.Latency.Seconds())
+} /*}}}*/
+
+//错误对应的status标签
+func Status(err error) string { /*{{{*/
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, memcache.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, memcache.ErrKeyExists):
+		return "key_exists"
+	case errors.Is(err, memcache.ErrNotStord):
+		return "not_stored"
+	case errors.Is(err, memcache.ErrBig):
+		return "too_large"
+	case errors.Is(err, memcache.ErrTLS):
+		return "tls_error"
+	case errors.Is(err, memcache.ErrNotConn):
+		return "not_conn"
+	case errors.Is(err, memcache.ErrBadConn):
+		return "bad_conn"
+	default:
+		return "error"
+	}
+} /*}}}*/
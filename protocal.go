@@ -1,5 +1,7 @@
 package memcache
 
+import "strconv"
+
 type magic_t uint8
 
 const (
@@ -20,11 +22,62 @@ const (
 	OP_FLUSH     opcode_t = 0x08
 	OP_NOOP      opcode_t = 0x0a
 	OP_VERSION   opcode_t = 0x0b
+	OP_GETQ      opcode_t = 0x09
 	OP_GETK      opcode_t = 0x0c
+	OP_GETKQ     opcode_t = 0x0d
 	OP_APPEND    opcode_t = 0x0e
 	OP_PREPEND   opcode_t = 0x0f
+	OP_SETQ      opcode_t = 0x11
+	OP_ADDQ      opcode_t = 0x12
+	OP_REPLACEQ  opcode_t = 0x13
+	OP_DELETEQ   opcode_t = 0x14
 )
 
+func (this opcode_t) String() string { /*{{{*/
+	switch this {
+	case OP_GET:
+		return "get"
+	case OP_SET:
+		return "set"
+	case OP_ADD:
+		return "add"
+	case OP_REPLACE:
+		return "replace"
+	case OP_DELETE:
+		return "delete"
+	case OP_INCREMENT:
+		return "increment"
+	case OP_DECREMENT:
+		return "decrement"
+	case OP_FLUSH:
+		return "flush"
+	case OP_NOOP:
+		return "noop"
+	case OP_VERSION:
+		return "version"
+	case OP_GETQ:
+		return "getq"
+	case OP_GETK:
+		return "getk"
+	case OP_GETKQ:
+		return "getkq"
+	case OP_APPEND:
+		return "append"
+	case OP_PREPEND:
+		return "prepend"
+	case OP_SETQ:
+		return "setq"
+	case OP_ADDQ:
+		return "addq"
+	case OP_REPLACEQ:
+		return "replaceq"
+	case OP_DELETEQ:
+		return "deleteq"
+	default:
+		return "opcode(0x" + strconv.FormatUint(uint64(this), 16) + ")"
+	}
+} /*}}}*/
+
 type status_t uint16
 
 const (
@@ -66,8 +119,67 @@ const (
 	VALUE_TYPE_FLOAT64 value_type_t = 0x00000800 //2048
 	VALUE_TYPE_STRING  value_type_t = 0x00001000 //4096
 	VALUE_TYPE_BOOL    value_type_t = 0x00002000 //8192
+
+	VALUE_TYPE_NEGATIVE value_type_t = 0x00004000 //16384 负缓存标记，表示key对应的数据不存在
+	VALUE_TYPE_STREAM   value_type_t = 0x00008000 //32768 SetStream写入的manifest
+	VALUE_TYPE_TAGGED   value_type_t = 0x00010000 //65536 SetWithTags写入，value后附加tag版本号
 )
 
+//client内部写入的value，允许使用保留的flags
+type reservedValue []byte
+
+//读取时按flags完全相等判断，用户自定义的flags可以包含这些位
+func isReservedFlags(flags uint32) bool { /*{{{*/
+	switch value_type_t(flags) {
+	case VALUE_TYPE_NEGATIVE, VALUE_TYPE_STREAM, VALUE_TYPE_TAGGED:
+		return true
+	}
+	return false
+} /*}}}*/
+
+func (this value_type_t) String() string { /*{{{*/
+	switch this {
+	case VALUE_TYPE_INT:
+		return "int"
+	case VALUE_TYPE_BIN:
+		return "bin"
+	case VALUE_TYPE_BYTE:
+		return "[]byte"
+	case VALUE_TYPE_INT8:
+		return "int8"
+	case VALUE_TYPE_INT16:
+		return "int16"
+	case VALUE_TYPE_INT32:
+		return "int32"
+	case VALUE_TYPE_INT64:
+		return "int64"
+	case VALUE_TYPE_UINT8:
+		return "uint8"
+	case VALUE_TYPE_UINT16:
+		return "uint16"
+	case VALUE_TYPE_UINT32:
+		return "uint32"
+	case VALUE_TYPE_UINT64:
+		return "uint64"
+	case VALUE_TYPE_FLOAT32:
+		return "float32"
+	case VALUE_TYPE_FLOAT64:
+		return "float64"
+	case VALUE_TYPE_STRING:
+		return "string"
+	case VALUE_TYPE_BOOL:
+		return "bool"
+	case VALUE_TYPE_NEGATIVE:
+		return "negative"
+	case VALUE_TYPE_STREAM:
+		return "stream"
+	case VALUE_TYPE_TAGGED:
+		return "tagged"
+	default:
+		return "unkown(" + strconv.FormatUint(uint64(this), 10) + ")"
+	}
+} /*}}}*/
+
 //request header
 type request_header struct {
 	magic    magic_t
@@ -95,7 +207,9 @@ type response_header struct {
 }
 
 type response struct {
-	header   *response_header
+	header   response_header
+	flags    value_type_t
 	bodyByte []byte
+	bodyBuf  *[]byte //bodyByte所在的缓冲区，来自bodyBufferPool
 	body     interface{}
 }
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pangudashu/memcache"
+)
+
+//TokenBucket读取-更新的最大重试次数
+var maxCasRetry = 10
+
+//令牌桶，桶内最多capacity个令牌，每秒补充rate个，允许不超过capacity的突发
+//状态为"令牌数:上次更新时间"，使用cas更新，不允许时不写入
+type TokenBucket struct {
+	mc       *memcache.Memcache
+	capacity int64
+	rate     float64
+}
+
+//capacity、rate不是正数(或rate为NaN、+Inf)时返回ErrInvalConfig
+func NewTokenBucket(mc *memcache.Memcache, capacity int64, rate float64) (*TokenBucket, error) { /*{{{*/
+	if capacity <= 0 || !(rate > 0) || math.IsInf(rate, 1) {
+		return nil, ErrInvalConfig
+	}
+	return &TokenBucket{mc: mc, capacity: capacity, rate: rate}, nil
+} /*}}}*/
+
+func (this *TokenBucket) Allow(key string) (*Result, error) { /*{{{*/
+	return this.AllowN(key, 1)
+} /*}}}*/
+
+//n不是正数时返回ErrInvalN
+func (this *TokenBucket) AllowN(key string, n int64) (*Result, error) { /*{{{*/
+	if n <= 0 {
+		return nil, ErrInvalN
+	}
+
+	bucket_key := keyPrefix + key
+	//桶补满后状态与不存在相同，过期时间为补满所需时间，超过time.Duration范围时不过期
+	var expire uint32
+	if fill := float64(this.capacity) / this.rate * float64(time.Second); fill < math.MaxInt64 {
+		expire = expireIn(time.Duration(fill))
+	}
+
+	for i := 0; i < maxCasRetry; i++ {
+		t := now()
+		tokens := float64(this.capacity)
+
+		item, err := this.mc.GetItem(bucket_key)
+		switch {
+		case err == nil:
+			tokens = this.refill(item.Value, t)
+		case !errors.Is(err, memcache.ErrNotFound):
+			return nil, err
+		}
+
+		res := this.result(tokens, n, t)
+		if !res.Allowed {
+			return res, nil
+		}
+
+		state := &memcache.Item{Key: bucket_key, Value: formatState(tokens-float64(n), t), Expiration: expire}
+		if item == nil {
+			_, err = this.mc.AddItem(state)
+		} else {
+			state.CAS = item.CAS
+			_, err = this.mc.CompareAndSwap(state)
+		}
+		switch {
+		case err == nil:
+			return res, nil
+		case errors.Is(err, memcache.ErrKeyExists), errors.Is(err, memcache.ErrNotStord), errors.Is(err, memcache.ErrNotFound):
+			//其它client已更新，重新读取
+			continue
+		default:
+			return nil, err
+		}
+	}
+	return nil, ErrContention
+} /*}}}*/
+
+//按上次更新到现在的时间补充令牌，状态无法解析时按桶满处理
+func (this *TokenBucket) refill(value interface{}, t time.Time) float64 { /*{{{*/
+	s, _ := value.(string)
+	tokens_str, last_str, ok := strings.Cut(s, ":")
+	if !ok {
+		return float64(this.capacity)
+	}
+	tokens, err1 := strconv.ParseFloat(tokens_str, 64)
+	last, err2 := strconv.ParseInt(last_str, 10, 64)
+	if err1 != nil || err2 != nil {
+		return float64(this.capacity)
+	}
+
+	if elapsed := t.UnixNano() - last; elapsed > 0 {
+		tokens += float64(elapsed) / float64(time.Second) * this.rate
+	}
+	return math.Min(tokens, float64(this.capacity))
+} /*}}}*/
+
+func (this *TokenBucket) result(tokens float64, n int64, t time.Time) *Result { /*{{{*/
+	res := &Result{Limit: this.capacity}
+	if tokens >= float64(n) {
+		res.Allowed = true
+		tokens -= float64(n)
+	} else if n <= this.capacity {
+		res.RetryAfter = this.fillTime(float64(n) - tokens)
+	} else {
+		//超过桶容量，永远不会允许
+		res.RetryAfter = -1
+	}
+	res.Remaining = int64(tokens)
+	res.ResetAt = t.Add(this.fillTime(float64(this.capacity) - tokens))
+	return res
+} /*}}}*/
+
+//补充tokens个令牌需要的时间
+func (this *TokenBucket) fillTime(tokens float64) time.Duration { /*{{{*/
+	return time.Duration(math.Ceil(tokens / this.rate * float64(time.Second)))
+} /*}}}*/
+
+func formatState(tokens float64, t time.Time) string { /*{{{*/
+	return strconv.FormatFloat(tokens, 'f', -1, 64) + ":" + strconv.FormatInt(t.UnixNano(), 10)
+} /*}}}*/
@@ -0,0 +1,59 @@
+//基于memcached计数器的分布式限流，多个应用server共用同一个memcached集群时限流全局生效
+//key按一致性哈希分配，同一个限流key的计数器在同一个server上，计数使用原子的incr/decr
+//
+//	limiter, err := ratelimit.NewFixedWindow(mc, 100, time.Minute)
+//	res, err := limiter.Allow("api:" + client_id)
+//	if err == nil && !res.Allowed {
+//		w.Header().Set("Retry-After", strconv.Itoa(int(res.RetryAfter.Seconds())+1))
+//	}
+package ratelimit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pangudashu/memcache"
+)
+
+var (
+	//TokenBucket并发更新冲突次数超过限制
+	ErrContention = errors.New("ratelimit: too many concurrent updates")
+	//limit、window、capacity、rate不是正数
+	ErrInvalConfig = errors.New("ratelimit: limit, window, capacity and rate must be positive")
+	//AllowN的n不是正数
+	ErrInvalN = errors.New("ratelimit: n must be positive")
+)
+
+type Result struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64         //剩余配额
+	ResetAt    time.Time     //窗口结束的时间，TokenBucket为令牌补满的时间
+	RetryAfter time.Duration //不允许时建议的重试等待时间，允许时为0，n超过TokenBucket容量时为-1
+}
+
+type Limiter interface {
+	Allow(key string) (*Result, error)
+	AllowN(key string, n int64) (*Result, error)
+}
+
+//计数器key的前缀
+var keyPrefix = "ratelimit:"
+
+//当前时间，测试时可以替换
+var now = time.Now
+
+//时长转为memcached的过期时间，多保留1秒，超过30天时转为unix时间戳
+//超过memcached可以表示的时间(2106年)时不过期
+func expireIn(d time.Duration) uint32 { /*{{{*/
+	expire, err := memcache.ExpireIn(d + time.Second)
+	if err != nil {
+		return 0
+	}
+	return expire
+} /*}}}*/
+
+//不允许时回滚计数，被拒绝的请求不占用配额，回滚失败不影响结果
+func rollback(mc *memcache.Memcache, key string, n int64) { /*{{{*/
+	mc.DecrementBy(key, uint64(n), 0, memcache.CounterNoCreate)
+} /*}}}*/
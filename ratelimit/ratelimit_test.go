@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pangudashu/memcache/internal/fakemc"
+)
+
+//固定当前时间，测试结束后还原
+func setNow(t *testing.T, tm *time.Time) { /*{{{*/
+	old := now
+	now = func() time.Time { return *tm }
+	t.Cleanup(func() { now = old })
+} /*}}}*/
+
+func checkResult(t *testing.T, name string, res *Result, err error, allowed bool, remaining int64, retry_after time.Duration) { /*{{{*/
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if res.Allowed != allowed || res.Remaining != remaining || res.RetryAfter != retry_after {
+		t.Fatalf("%s: got allowed=%v remaining=%d retry_after=%v, want %v %d %v", name, res.Allowed, res.Remaining, res.RetryAfter, allowed, remaining, retry_after)
+	}
+} /*}}}*/
+
+func TestNewInvalConfig(t *testing.T) { /*{{{*/
+	for _, window := range []time.Duration{0, -time.Second} {
+		if _, err := NewFixedWindow(nil, 10, window); !errors.Is(err, ErrInvalConfig) {
+			t.Errorf("NewFixedWindow window %v: err = %v", window, err)
+		}
+		if _, err := NewSlidingWindow(nil, 10, window); !errors.Is(err, ErrInvalConfig) {
+			t.Errorf("NewSlidingWindow window %v: err = %v", window, err)
+		}
+	}
+	for _, limit := range []int64{0, -1} {
+		if _, err := NewFixedWindow(nil, limit, time.Second); !errors.Is(err, ErrInvalConfig) {
+			t.Errorf("NewFixedWindow limit %d: err = %v", limit, err)
+		}
+		if _, err := NewSlidingWindow(nil, limit, time.Second); !errors.Is(err, ErrInvalConfig) {
+			t.Errorf("NewSlidingWindow limit %d: err = %v", limit, err)
+		}
+		if _, err := NewTokenBucket(nil, limit, 1); !errors.Is(err, ErrInvalConfig) {
+			t.Errorf("NewTokenBucket capacity %d: err = %v", limit, err)
+		}
+	}
+	for _, rate := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if _, err := NewTokenBucket(nil, 10, rate); !errors.Is(err, ErrInvalConfig) {
+			t.Errorf("NewTokenBucket rate %v: err = %v", rate, err)
+		}
+	}
+
+	if _, err := NewFixedWindow(nil, 10, time.Second); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewSlidingWindow(nil, 10, time.Second); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewTokenBucket(nil, 10, 0.5); err != nil {
+		t.Error(err)
+	}
+} /*}}}*/
+
+func TestFixedWindow(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 2)
+	tm := time.Unix(1000, 0)
+	setNow(t, &tm)
+
+	limiter, err := NewFixedWindow(mc, 3, time.Second*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3; i++ {
+		res, err := limiter.Allow("a")
+		checkResult(t, "allow", res, err, true, 2-i, 0)
+	}
+	res, err := limiter.Allow("a")
+	checkResult(t, "over limit", res, err, false, 0, time.Second*10)
+	if !res.ResetAt.Equal(time.Unix(1010, 0)) || res.Limit != 3 {
+		t.Fatalf("ResetAt = %v, Limit = %d", res.ResetAt, res.Limit)
+	}
+
+	//不同的key分别计数
+	res, err = limiter.Allow("b")
+	checkResult(t, "other key", res, err, true, 2, 0)
+
+	//被拒绝的请求不占用配额
+	tm = time.Unix(1007, 0)
+	res, err = limiter.AllowN("a", 2)
+	checkResult(t, "denied n", res, err, false, 0, time.Second*3)
+
+	//窗口切换后配额重置
+	tm = time.Unix(1010, 0)
+	res, err = limiter.AllowN("a", 3)
+	checkResult(t, "next window", res, err, true, 0, 0)
+} /*}}}*/
+
+func TestSlidingWindow(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 2)
+	tm := time.Unix(1000, 0)
+	setNow(t, &tm)
+
+	limiter, err := NewSlidingWindow(mc, 10, time.Second*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := limiter.AllowN("a", 10)
+	checkResult(t, "allow", res, err, true, 0, 0)
+	res, err = limiter.Allow("a")
+	checkResult(t, "over limit", res, err, false, 0, time.Second*10)
+
+	//下一个窗口过去一半，上一个窗口的计数按一半计入
+	tm = time.Unix(1015, 0)
+	res, err = limiter.AllowN("a", 4)
+	checkResult(t, "half window", res, err, true, 1, 0)
+	res, err = limiter.AllowN("a", 2)
+	//上一个窗口计数减少1所需的时间为1s
+	checkResult(t, "over limit in next window", res, err, false, 1, time.Second)
+	if !res.ResetAt.Equal(time.Unix(1020, 0)) {
+		t.Fatalf("ResetAt = %v", res.ResetAt)
+	}
+
+	//上一个窗口过去后不再计入
+	tm = time.Unix(1030, 0)
+	res, err = limiter.AllowN("a", 10)
+	checkResult(t, "after two windows", res, err, true, 0, 0)
+} /*}}}*/
+
+func TestTokenBucket(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 2)
+	tm := time.Unix(1000, 0)
+	setNow(t, &tm)
+
+	limiter, err := NewTokenBucket(mc, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := limiter.AllowN("a", 10)
+	checkResult(t, "burst", res, err, true, 0, 0)
+	if !res.ResetAt.Equal(time.Unix(1005, 0)) {
+		t.Fatalf("ResetAt = %v", res.ResetAt)
+	}
+	res, err = limiter.Allow("a")
+	checkResult(t, "empty", res, err, false, 0, time.Millisecond*500)
+
+	//每秒补充2个令牌
+	tm = time.Unix(1002, 0)
+	res, err = limiter.AllowN("a", 3)
+	checkResult(t, "refill", res, err, true, 1, 0)
+	res, err = limiter.AllowN("a", 4)
+	checkResult(t, "not enough", res, err, false, 1, time.Millisecond*1500)
+
+	//超过容量，永远不会允许
+	res, err = limiter.AllowN("a", 11)
+	checkResult(t, "over capacity", res, err, false, 1, -1)
+
+	//补满后不超过容量
+	tm = time.Unix(2000, 0)
+	res, err = limiter.Allow("a")
+	checkResult(t, "full", res, err, true, 9, 0)
+} /*}}}*/
+
+//窗口、补满时间超过30天时过期时间转为unix时间戳，不能返回ErrInvalExpire
+func TestLongPeriod(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 1)
+	day := time.Hour * 24
+
+	fixed, _ := NewFixedWindow(mc, 2, day*31)
+	sliding, _ := NewSlidingWindow(mc, 2, day*16)
+	bucket, _ := NewTokenBucket(mc, 2, 1.0/float64(day*31/time.Second))
+	huge, _ := NewTokenBucket(mc, 1e9, 1e-3)
+	limiters := map[string]Limiter{"fixed": fixed, "sliding": sliding, "bucket": bucket, "huge_bucket": huge}
+
+	for name, limiter := range limiters {
+		res, err := limiter.Allow(name)
+		if err != nil || !res.Allowed {
+			t.Fatalf("%s: Allow = %+v, %v", name, res, err)
+		}
+		//计数器已经保存，没有立即过期
+		res, err = limiter.Allow(name)
+		if err != nil || !res.Allowed || res.Remaining != res.Limit-2 {
+			t.Fatalf("%s: second Allow = %+v, %v", name, res, err)
+		}
+	}
+} /*}}}*/
+
+func TestAllowInvalN(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 1)
+
+	fixed, _ := NewFixedWindow(mc, 2, time.Minute)
+	sliding, _ := NewSlidingWindow(mc, 2, time.Minute)
+	bucket, _ := NewTokenBucket(mc, 2, 1)
+	limiters := map[string]Limiter{"fixed": fixed, "sliding": sliding, "bucket": bucket}
+
+	for name, limiter := range limiters {
+		limiter.AllowN("key", 2)
+		for _, n := range []int64{0, -1, -100} {
+			if _, err := limiter.AllowN("key", n); !errors.Is(err, ErrInvalN) {
+				t.Errorf("%s: AllowN(%d) err = %v, want ErrInvalN", name, n, err)
+			}
+		}
+		//负数不能返还配额
+		if res, err := limiter.Allow("key"); err != nil || res.Allowed {
+			t.Errorf("%s: Allow after negative n = %+v, %v", name, res, err)
+		}
+	}
+} /*}}}*/
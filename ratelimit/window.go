@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/pangudashu/memcache"
+)
+
+//固定窗口，每个窗口一个计数器，窗口切换时配额重置，窗口边界可能出现2倍的突发
+type FixedWindow struct {
+	mc     *memcache.Memcache
+	limit  int64
+	window time.Duration
+}
+
+//limit、window不是正数时返回ErrInvalConfig
+func NewFixedWindow(mc *memcache.Memcache, limit int64, window time.Duration) (*FixedWindow, error) { /*{{{*/
+	if limit <= 0 || window <= 0 {
+		return nil, ErrInvalConfig
+	}
+	return &FixedWindow{mc: mc, limit: limit, window: window}, nil
+} /*}}}*/
+
+func (this *FixedWindow) Allow(key string) (*Result, error) { /*{{{*/
+	return this.AllowN(key, 1)
+} /*}}}*/
+
+//n不是正数时返回ErrInvalN
+func (this *FixedWindow) AllowN(key string, n int64) (*Result, error) { /*{{{*/
+	if n <= 0 {
+		return nil, ErrInvalN
+	}
+
+	t := now()
+	index := t.UnixNano() / int64(this.window)
+	counter_key := windowKey(key, index)
+	reset := time.Unix(0, (index+1)*int64(this.window))
+
+	count, err := this.mc.IncrementBy(counter_key, uint64(n), uint64(n), expireIn(this.window))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{Limit: this.limit, ResetAt: reset}
+	if int64(count) > this.limit {
+		rollback(this.mc, counter_key, n)
+		res.Remaining = remaining(this.limit, int64(count)-n)
+		res.RetryAfter = reset.Sub(t)
+		return res, nil
+	}
+
+	res.Allowed = true
+	res.Remaining = remaining(this.limit, int64(count))
+	return res, nil
+} /*}}}*/
+
+//滑动窗口，使用当前窗口与上一个窗口的计数近似：上一个窗口的计数按未过去的比例计入
+//只需要两个计数器，相比记录每个请求时间的滑动日志节省内存，窗口边界不会出现突发
+type SlidingWindow struct {
+	mc     *memcache.Memcache
+	limit  int64
+	window time.Duration
+}
+
+//limit、window不是正数时返回ErrInvalConfig
+func NewSlidingWindow(mc *memcache.Memcache, limit int64, window time.Duration) (*SlidingWindow, error) { /*{{{*/
+	if limit <= 0 || window <= 0 {
+		return nil, ErrInvalConfig
+	}
+	return &SlidingWindow{mc: mc, limit: limit, window: window}, nil
+} /*}}}*/
+
+func (this *SlidingWindow) Allow(key string) (*Result, error) { /*{{{*/
+	return this.AllowN(key, 1)
+} /*}}}*/
+
+//n不是正数时返回ErrInvalN
+func (this *SlidingWindow) AllowN(key string, n int64) (*Result, error) { /*{{{*/
+	if n <= 0 {
+		return nil, ErrInvalN
+	}
+
+	t := now()
+	index := t.UnixNano() / int64(this.window)
+	counter_key := windowKey(key, index)
+	reset := time.Unix(0, (index+1)*int64(this.window))
+
+	//上一个窗口的计数需要保留到当前窗口结束
+	count, err := this.mc.IncrementBy(counter_key, uint64(n), uint64(n), expireIn(this.window*2))
+	if err != nil {
+		return nil, err
+	}
+
+	var prev int64
+	value, _, err := this.mc.Get(windowKey(key, index-1))
+	switch {
+	case err == nil:
+		v, _ := value.(int)
+		prev = int64(v)
+	case !errors.Is(err, memcache.ErrNotFound):
+		return nil, err
+	}
+
+	//上一个窗口计数的权重为当前窗口未过去的比例
+	elapsed := t.UnixNano() - index*int64(this.window)
+	weight := 1 - float64(elapsed)/float64(this.window)
+	estimated := int64(float64(prev)*weight) + int64(count)
+
+	res := &Result{Limit: this.limit, ResetAt: reset}
+	if estimated > this.limit {
+		rollback(this.mc, counter_key, n)
+		res.Remaining = remaining(this.limit, estimated-n)
+		res.RetryAfter = this.retryAfter(prev, weight, estimated, reset.Sub(t))
+		return res, nil
+	}
+
+	res.Allowed = true
+	res.Remaining = remaining(this.limit, estimated)
+	return res, nil
+} /*}}}*/
+
+//上一个窗口的计数随时间线性减少，估算减少到允许本次请求所需的时间，超过窗口结束时间时返回窗口结束时间
+func (this *SlidingWindow) retryAfter(prev int64, weight float64, estimated int64, until_reset time.Duration) time.Duration { /*{{{*/
+	need := float64(estimated - this.limit)
+	if prev == 0 || need > float64(prev)*weight {
+		return until_reset
+	}
+	return time.Duration(need / float64(prev) * float64(this.window))
+} /*}}}*/
+
+func windowKey(key string, index int64) string { /*{{{*/
+	return keyPrefix + key + ":" + strconv.FormatInt(index, 10)
+} /*}}}*/
+
+func remaining(limit int64, used int64) int64 { /*{{{*/
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+} /*}}}*/
@@ -2,6 +2,7 @@ package memcache
 
 import (
 	"crypto/md5"
+	"crypto/tls"
 	"hash/crc32"
 	"math"
 	"strconv"
@@ -13,14 +14,17 @@ const (
 )
 
 type Server struct {
-	Address  string
-	Weight   int
-	MaxConn  int
-	InitConn int
-	IdleTime time.Duration
-	isActive bool
-	pool     *ConnectionPool
-	nodeList []uint32
+	Address   string
+	Weight    int
+	MaxConn   int
+	InitConn  int
+	IdleTime  time.Duration
+	TLSConfig *tls.Config //非nil时使用TLS连接，客户端证书通过TLSConfig.Certificates设置
+	Dialer    DialFunc    //自定义建立连接的方法，nil时使用net.Dialer(默认开启keepalive)
+	isActive  bool
+	pool      *ConnectionPool
+	nodeList  []uint32
+	log       *eventLog
 }
 
 type Nodes struct {
@@ -51,7 +55,7 @@ func createServerNode(servers []*Server) *Nodes { /*{{{*/
 	for _, s := range servers {
 		//create connection pool
 		if s.pool == nil {
-			s.pool = open(s.Address, s.MaxConn, s.InitConn, s.IdleTime)
+			s.pool = open(s.Address, s.MaxConn, s.InitConn, s.IdleTime, s.Dialer, s.TLSConfig, s.log)
 		}
 
 		//计算实际分配的虚拟节点数
@@ -144,6 +148,19 @@ func (nodes *Nodes) getServerByKey(key string) *Server { /*{{{*/
 	}
 } /*}}}*/
 
+//按server对key分组，用于批量操作
+func (nodes *Nodes) groupByServer(keys []string) (map[*Server][]string, error) { /*{{{*/
+	groups := make(map[*Server][]string)
+	for _, key := range keys {
+		server := nodes.getServerByKey(key)
+		if server == nil {
+			return nil, ErrNotConn
+		}
+		groups[server] = append(groups[server], key)
+	}
+	return groups, nil
+} /*}}}*/
+
 //折半查找
 func (nodes *Nodes) getNodeByHash(hash_key uint32) (node uint32) { /*{{{*/
 	if nodes.nodeCnt < 1 {
@@ -0,0 +1,140 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/pangudashu/memcache"
+)
+
+type contextKey struct{}
+
+//读取Middleware放入请求context的session，不存在时返回nil
+func FromContext(ctx context.Context) *Session { /*{{{*/
+	sess, _ := ctx.Value(contextKey{}).(*Session)
+	return sess
+} /*}}}*/
+
+//读取cookie中的session放入请求context，没有cookie或session已过期时创建新session
+//在写入响应头之前保存：修改过的session使用cas保存，未修改的重新写入原数据续期，Destroy的删除并清除cookie
+//新session未修改时不保存也不写cookie，避免为每个匿名请求创建session
+func (this *Store) Middleware(next http.Handler) http.Handler { /*{{{*/
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, err := this.loadRequest(r)
+		if err != nil {
+			this.opts.ErrorHandler(w, r, err)
+			return
+		}
+
+		sw := &responseWriter{ResponseWriter: w, store: this, req: r, sess: sess}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKey{}, sess)))
+		sw.commit()
+	})
+} /*}}}*/
+
+func (this *Store) loadRequest(r *http.Request) (*Session, error) { /*{{{*/
+	cookie, err := r.Cookie(this.opts.CookieName)
+	if err != nil {
+		return this.New()
+	}
+
+	sess, err := this.Load(cookie.Value)
+	if errors.Is(err, memcache.ErrNotFound) || errors.Is(err, ErrInvalidID) {
+		return this.New()
+	}
+	return sess, err
+} /*}}}*/
+
+//保存session并写入cookie，失败时调用ErrorHandler并返回false
+func (this *Store) commit(w http.ResponseWriter, r *http.Request, sess *Session) bool { /*{{{*/
+	sess.mu.Lock()
+	modified, destroyed, saved := sess.modified, sess.destroyed, sess.cas != 0
+	sess.mu.Unlock()
+
+	switch {
+	case destroyed:
+		if err := this.Destroy(sess); err != nil {
+			this.opts.ErrorHandler(w, r, err)
+			return false
+		}
+		http.SetCookie(w, this.cookie("", -1))
+		return true
+	case modified:
+		if err := this.Save(sess); err != nil {
+			this.opts.ErrorHandler(w, r, err)
+			return false
+		}
+	case saved:
+		//续期失败不影响本次请求，ErrConflict表示其它请求已经写入
+		this.Touch(sess)
+	default:
+		return true
+	}
+
+	http.SetCookie(w, this.cookie(sess.id, int(this.opts.MaxAge.Seconds())))
+	return true
+} /*}}}*/
+
+func (this *Store) cookie(value string, max_age int) *http.Cookie { /*{{{*/
+	return &http.Cookie{
+		Name:     this.opts.CookieName,
+		Value:    value,
+		Path:     this.opts.Path,
+		Domain:   this.opts.Domain,
+		MaxAge:   max_age,
+		Secure:   this.opts.Secure,
+		HttpOnly: true,
+		SameSite: this.opts.SameSite,
+	}
+} /*}}}*/
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) { /*{{{*/
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+} /*}}}*/
+
+//第一次写入响应头之前保存session，保存失败时丢弃handler后续写入的响应
+type responseWriter struct {
+	http.ResponseWriter
+	store *Store
+	req   *http.Request
+	sess  *Session
+
+	committed bool
+	failed    bool
+}
+
+func (this *responseWriter) commit() bool { /*{{{*/
+	if !this.committed {
+		this.committed = true
+		this.failed = !this.store.commit(this.ResponseWriter, this.req, this.sess)
+	}
+	return !this.failed
+} /*}}}*/
+
+func (this *responseWriter) WriteHeader(code int) { /*{{{*/
+	if this.commit() {
+		this.ResponseWriter.WriteHeader(code)
+	}
+} /*}}}*/
+
+func (this *responseWriter) Write(b []byte) (int, error) { /*{{{*/
+	if !this.commit() {
+		return len(b), nil
+	}
+	return this.ResponseWriter.Write(b)
+} /*}}}*/
+
+func (this *responseWriter) Flush() { /*{{{*/
+	if !this.commit() {
+		return
+	}
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+} /*}}}*/
+
+//用于http.ResponseController
+func (this *responseWriter) Unwrap() http.ResponseWriter { /*{{{*/
+	return this.ResponseWriter
+} /*}}}*/
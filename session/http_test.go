@@ -0,0 +1,116 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pangudashu/memcache/internal/fakemc"
+)
+
+func serve(handler http.Handler, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder { /*{{{*/
+	req := httptest.NewRequest("GET", path, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+} /*}}}*/
+
+func TestMiddleware(t *testing.T) { /*{{{*/
+	mc, servers := fakemc.NewClient(t, 1)
+	store := NewStore(mc, nil)
+
+	var saved bool
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := FromContext(r.Context())
+		switch r.URL.Path {
+		case "/login":
+			sess.Set("user", "bob")
+		case "/logout":
+			sess.Destroy()
+		}
+		user, _ := sess.Get("user").(string)
+		w.Write([]byte(user))
+
+		//写入响应时已经保存
+		if r.URL.Path == "/login" {
+			loaded, err := store.Load(sess.ID())
+			saved = err == nil && loaded.Get("user") == "bob"
+		}
+	}))
+
+	//新session未修改时不保存也不写cookie
+	ops := servers[0].Ops()
+	rec := serve(handler, "/")
+	if len(rec.Result().Cookies()) != 0 || servers[0].Ops() != ops {
+		t.Fatalf("anonymous request: cookies = %v, sent %d requests", rec.Result().Cookies(), servers[0].Ops()-ops)
+	}
+
+	rec = serve(handler, "/login")
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session_id" || cookies[0].MaxAge != 1800 || !cookies[0].HttpOnly {
+		t.Fatalf("login cookies = %v", cookies)
+	}
+	if !saved {
+		t.Fatal("session not saved before the response was written")
+	}
+	id := cookies[0].Value
+
+	//未修改的session续期，数据不变
+	rec = serve(handler, "/", cookies[0])
+	if rec.Body.String() != "bob" || len(rec.Result().Cookies()) != 1 {
+		t.Fatalf("body = %q, cookies = %v", rec.Body.String(), rec.Result().Cookies())
+	}
+
+	rec = serve(handler, "/logout", cookies[0])
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].MaxAge != -1 {
+		t.Fatalf("logout cookies = %v", c)
+	}
+	if _, err := store.Load(id); err == nil {
+		t.Fatal("session not deleted after Destroy")
+	}
+
+	//已删除的session按新session处理
+	rec = serve(handler, "/", cookies[0])
+	if rec.Body.String() != "" || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("after logout: body = %q, cookies = %v", rec.Body.String(), rec.Result().Cookies())
+	}
+} /*}}}*/
+
+//保存失败时调用ErrorHandler，丢弃handler写入的响应
+func TestMiddlewareConflict(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 1)
+	var handled error
+	store := NewStore(mc, &Options{ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.WriteHeader(http.StatusConflict)
+	}})
+
+	sess, _ := store.New()
+	sess.Set("count", 0)
+	store.Save(sess)
+	cookie := store.cookie(sess.ID(), 0)
+
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := FromContext(r.Context())
+		sess.Set("count", 1)
+
+		//其它请求在此期间修改了session
+		other, _ := store.Load(sess.ID())
+		other.Set("count", 2)
+		store.Save(other)
+
+		w.Write([]byte("ok"))
+	}))
+
+	rec := serve(handler, "/", cookie)
+	if !errors.Is(handled, ErrConflict) || rec.Code != http.StatusConflict || rec.Body.String() != "" {
+		t.Fatalf("handled = %v, code = %d, body = %q", handled, rec.Code, rec.Body.String())
+	}
+	if loaded, _ := store.Load(sess.ID()); loaded.Get("count") != 2 {
+		t.Fatalf("count = %v, want 2", loaded.Get("count"))
+	}
+} /*}}}*/
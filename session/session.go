@@ -0,0 +1,117 @@
+//基于memcached的session存储，session按id分布到集群的各server上
+//
+//	store := session.NewStore(mc, &session.Options{MaxAge: time.Hour, Secure: true})
+//	http.Handle("/", store.Middleware(handler))
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		sess := session.FromContext(r.Context())
+//		sess.Set("user_id", 1)
+//	}
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var (
+	//保存时session已被其它请求修改、删除或已过期
+	ErrConflict = errors.New("session: modified by another request")
+	//session id格式错误，不是Store生成的id
+	ErrInvalidID = errors.New("session: invalid session id")
+)
+
+//session数据的序列化方式
+type Codec interface {
+	Encode(values map[string]interface{}) ([]byte, error)
+	Decode(data []byte) (map[string]interface{}, error)
+}
+
+//gob序列化，保留value的类型，自定义类型需要先gob.Register
+type GobCodec struct{}
+
+func (GobCodec) Encode(values map[string]interface{}) ([]byte, error) { /*{{{*/
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+} /*}}}*/
+
+func (GobCodec) Decode(data []byte) (map[string]interface{}, error) { /*{{{*/
+	var values map[string]interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+} /*}}}*/
+
+//json序列化，便于其它语言读取，数值读取后为float64
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(values map[string]interface{}) ([]byte, error) { /*{{{*/
+	return json.Marshal(values)
+} /*}}}*/
+
+func (JSONCodec) Decode(data []byte) (map[string]interface{}, error) { /*{{{*/
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+} /*}}}*/
+
+type Session struct {
+	id     string
+	values map[string]interface{}
+	data   []byte //读取时的原始数据，未修改时按原数据续期
+	cas    uint64 //读取、保存后服务端返回的cas，0表示未保存过的新session
+
+	modified  bool
+	destroyed bool
+
+	mu sync.Mutex
+}
+
+func (this *Session) ID() string { /*{{{*/
+	return this.id
+} /*}}}*/
+
+//是否为新创建、还未保存的session
+func (this *Session) IsNew() bool { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.cas == 0
+} /*}}}*/
+
+func (this *Session) Get(name string) interface{} { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.values[name]
+} /*}}}*/
+
+func (this *Session) Set(name string, value interface{}) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.values[name] = value
+	this.modified = true
+} /*}}}*/
+
+func (this *Session) Delete(name string) { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if _, ok := this.values[name]; ok {
+		delete(this.values, name)
+		this.modified = true
+	}
+} /*}}}*/
+
+//标记删除，由Middleware在请求结束时删除存储的数据并清除cookie
+func (this *Session) Destroy() { /*{{{*/
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.destroyed = true
+} /*}}}*/
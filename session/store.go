@@ -0,0 +1,180 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pangudashu/memcache"
+)
+
+//session id的随机字节数，编码为43个字符的base64url
+const idBytes = 32
+
+type Options struct {
+	MaxAge    time.Duration //session有效期，每次请求后重新计算(滑动过期)，默认30分钟
+	Codec     Codec         //默认GobCodec
+	KeyPrefix string        //存储的key前缀，默认"session:"
+
+	//Middleware使用的cookie，HttpOnly固定开启
+	CookieName string //默认"session_id"
+	Path       string //默认"/"
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite //默认http.SameSiteLaxMode
+
+	//Middleware读取、保存session失败时调用，此时还未写入响应头，默认返回500
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+type Store struct {
+	mc   *memcache.Memcache
+	opts Options
+}
+
+func NewStore(mc *memcache.Memcache, opts *Options) *Store { /*{{{*/
+	store := &Store{mc: mc}
+	if opts != nil {
+		store.opts = *opts
+	}
+
+	if store.opts.MaxAge <= 0 {
+		store.opts.MaxAge = time.Minute * 30
+	}
+	if store.opts.Codec == nil {
+		store.opts.Codec = GobCodec{}
+	}
+	if store.opts.KeyPrefix == "" {
+		store.opts.KeyPrefix = "session:"
+	}
+	if store.opts.CookieName == "" {
+		store.opts.CookieName = "session_id"
+	}
+	if store.opts.Path == "" {
+		store.opts.Path = "/"
+	}
+	if store.opts.SameSite == 0 {
+		store.opts.SameSite = http.SameSiteLaxMode
+	}
+	if store.opts.ErrorHandler == nil {
+		store.opts.ErrorHandler = defaultErrorHandler
+	}
+	return store
+} /*}}}*/
+
+//创建新session，id使用crypto/rand生成，调用Save后才会写入memcached
+func (this *Store) New() (*Session, error) { /*{{{*/
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &Session{id: base64.RawURLEncoding.EncodeToString(b), values: make(map[string]interface{})}, nil
+} /*}}}*/
+
+//读取session，不存在或已过期时返回memcache.ErrNotFound，id格式错误时返回ErrInvalidID
+func (this *Store) Load(id string) (*Session, error) { /*{{{*/
+	if !validID(id) {
+		return nil, ErrInvalidID
+	}
+
+	item, err := this.mc.GetItem(this.opts.KeyPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := item.Value.([]byte)
+	if !ok {
+		return nil, memcache.ErrInvalFormat
+	}
+	values, err := this.opts.Codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	return &Session{id: id, values: values, data: data, cas: item.CAS}, nil
+} /*}}}*/
+
+//保存session并重新设置有效期，新session使用Add写入，其它使用读取时的cas写入
+//读取后被其它请求修改、删除或已过期时返回ErrConflict，避免并发请求互相覆盖
+func (this *Store) Save(sess *Session) error { /*{{{*/
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	data, err := this.opts.Codec.Encode(sess.values)
+	if err != nil {
+		return err
+	}
+	cas, err := this.store(sess, data)
+	if err != nil {
+		return err
+	}
+	sess.data = data
+	sess.cas = cas
+	sess.modified = false
+	return nil
+} /*}}}*/
+
+//不修改数据，重新设置有效期，使用cas写入读取时的原始数据
+//新session不需要续期，读取后被其它请求修改、删除时返回ErrConflict
+func (this *Store) Touch(sess *Session) error { /*{{{*/
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.cas == 0 {
+		return nil
+	}
+	cas, err := this.store(sess, sess.data)
+	if err != nil {
+		return err
+	}
+	sess.cas = cas
+	return nil
+} /*}}}*/
+
+//删除session，不检查cas，不存在时不返回错误
+func (this *Store) Destroy(sess *Session) error { /*{{{*/
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.destroyed = true
+	sess.cas = 0
+	_, err := this.mc.Delete(this.opts.KeyPrefix + sess.id)
+	if errors.Is(err, memcache.ErrNotFound) {
+		return nil
+	}
+	return err
+} /*}}}*/
+
+func (this *Store) store(sess *Session, data []byte) (cas uint64, err error) { /*{{{*/
+	expire, err := memcache.ExpireIn(this.opts.MaxAge)
+	if err != nil {
+		return 0, err
+	}
+	item := &memcache.Item{Key: this.opts.KeyPrefix + sess.id, Value: data, Expiration: expire, CAS: sess.cas}
+
+	if sess.cas == 0 {
+		cas, err = this.mc.AddItem(item)
+	} else {
+		cas, err = this.mc.CompareAndSwap(item)
+	}
+	if errors.Is(err, memcache.ErrKeyExists) || errors.Is(err, memcache.ErrNotFound) || errors.Is(err, memcache.ErrNotStord) {
+		return 0, ErrConflict
+	}
+	return cas, err
+} /*}}}*/
+
+func validID(id string) bool { /*{{{*/
+	if len(id) != base64.RawURLEncoding.EncodedLen(idBytes) {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+} /*}}}*/
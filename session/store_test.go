@@ -0,0 +1,154 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pangudashu/memcache"
+	"github.com/pangudashu/memcache/internal/fakemc"
+)
+
+func TestStoreSave(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 2)
+	store := NewStore(mc, nil)
+
+	sess, err := store.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sess.IsNew() || len(sess.ID()) != 43 {
+		t.Fatalf("new session: IsNew = %v, ID = %q", sess.IsNew(), sess.ID())
+	}
+	sess.Set("user_id", 1)
+	if err := store.Save(sess); err != nil {
+		t.Fatal(err)
+	}
+	if sess.IsNew() {
+		t.Fatal("IsNew after Save")
+	}
+
+	loaded, err := store.Load(sess.ID())
+	if err != nil || loaded.Get("user_id") != 1 {
+		t.Fatalf("Load = %v, %v", loaded, err)
+	}
+
+	//id相同的新session不能覆盖已保存的session
+	dup := &Session{id: sess.ID(), values: map[string]interface{}{"user_id": 2}}
+	if err := store.Save(dup); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Save duplicate new session err = %v, want ErrConflict", err)
+	}
+
+	if _, err := store.Load("invalid id"); !errors.Is(err, ErrInvalidID) {
+		t.Fatalf("Load invalid id err = %v, want ErrInvalidID", err)
+	}
+	other, _ := store.New()
+	if _, err := store.Load(other.ID()); !errors.Is(err, memcache.ErrNotFound) {
+		t.Fatalf("Load unsaved session err = %v, want ErrNotFound", err)
+	}
+
+	json_store := NewStore(mc, &Options{Codec: JSONCodec{}, KeyPrefix: "json:"})
+	sess, _ = json_store.New()
+	sess.Set("count", 3)
+	json_store.Save(sess)
+	if loaded, err := json_store.Load(sess.ID()); err != nil || loaded.Get("count") != float64(3) {
+		t.Fatalf("JSONCodec Load = %v, %v", loaded, err)
+	}
+} /*}}}*/
+
+//读取后被其它请求修改、删除时返回ErrConflict，不会互相覆盖
+func TestStoreSaveConflict(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 1)
+	store := NewStore(mc, nil)
+
+	sess, _ := store.New()
+	sess.Set("a", 1)
+	store.Save(sess)
+
+	first, _ := store.Load(sess.ID())
+	second, _ := store.Load(sess.ID())
+	first.Set("b", 2)
+	if err := store.Save(first); err != nil {
+		t.Fatal(err)
+	}
+	second.Set("c", 3)
+	if err := store.Save(second); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Save after concurrent update err = %v, want ErrConflict", err)
+	}
+
+	loaded, _ := store.Load(sess.ID())
+	if loaded.Get("b") != 2 || loaded.Get("c") != nil {
+		t.Fatalf("stored values = %v", loaded.values)
+	}
+
+	//保存成功后使用新的cas，可以继续保存
+	first.Set("d", 4)
+	if err := store.Save(first); err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+} /*}}}*/
+
+func TestStoreTouch(t *testing.T) { /*{{{*/
+	mc, servers := fakemc.NewClient(t, 1)
+	store := NewStore(mc, &Options{MaxAge: time.Hour})
+
+	//新session不需要续期
+	sess, _ := store.New()
+	ops := servers[0].Ops()
+	if err := store.Touch(sess); err != nil || servers[0].Ops() != ops {
+		t.Fatalf("Touch new session = %v, sent %d requests", err, servers[0].Ops()-ops)
+	}
+
+	sess.Set("a", 1)
+	store.Save(sess)
+	loaded, _ := store.Load(sess.ID())
+	cas := loaded.cas
+	if err := store.Touch(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.cas == cas {
+		t.Fatal("cas not updated after Touch")
+	}
+	//续期后数据不变
+	if again, err := store.Load(sess.ID()); err != nil || again.Get("a") != 1 {
+		t.Fatalf("Load after Touch = %v, %v", again, err)
+	}
+
+	//读取后被其它请求修改时不能用旧数据覆盖
+	stale, _ := store.Load(sess.ID())
+	loaded.Set("a", 2)
+	store.Save(loaded)
+	if err := store.Touch(stale); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Touch stale session err = %v, want ErrConflict", err)
+	}
+	if again, _ := store.Load(sess.ID()); again.Get("a") != 2 {
+		t.Fatalf("value = %v after stale Touch, want 2", again.Get("a"))
+	}
+} /*}}}*/
+
+func TestStoreDestroy(t *testing.T) { /*{{{*/
+	mc, _ := fakemc.NewClient(t, 1)
+	store := NewStore(mc, nil)
+
+	sess, _ := store.New()
+	sess.Set("a", 1)
+	store.Save(sess)
+	stale, _ := store.Load(sess.ID())
+
+	if err := store.Destroy(sess); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(sess.ID()); !errors.Is(err, memcache.ErrNotFound) {
+		t.Fatalf("Load after Destroy err = %v, want ErrNotFound", err)
+	}
+	//不存在时不返回错误
+	if err := store.Destroy(sess); err != nil {
+		t.Fatalf("second Destroy: %v", err)
+	}
+
+	//已删除的session不能被其它请求重新写入
+	stale.Set("b", 2)
+	if err := store.Save(stale); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Save destroyed session err = %v, want ErrConflict", err)
+	}
+} /*}}}*/
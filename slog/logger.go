@@ -0,0 +1,42 @@
+//log/slog适配，单独的包避免memcache要求Go 1.21
+//
+//	mc, _ := memcache.NewMemcache(servers)
+//	mc.SetLogger(memslog.NewLogger(slog.Default()))
+package slog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pangudashu/memcache"
+)
+
+type Logger struct {
+	logger *slog.Logger
+}
+
+//logger为nil时使用slog.Default()
+func NewLogger(logger *slog.Logger) *Logger { /*{{{*/
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Logger{logger: logger}
+} /*}}}*/
+
+func (this *Logger) Log(level memcache.LogLevel, msg string, args ...interface{}) { /*{{{*/
+	this.logger.Log(context.Background(), Level(level), msg, args...)
+} /*}}}*/
+
+//memcache日志级别对应的slog级别
+func Level(level memcache.LogLevel) slog.Level { /*{{{*/
+	switch level {
+	case memcache.LogDebug:
+		return slog.LevelDebug
+	case memcache.LogInfo:
+		return slog.LevelInfo
+	case memcache.LogWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+} /*}}}*/
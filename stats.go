@@ -0,0 +1,64 @@
+package memcache
+
+import (
+	"context"
+	"time"
+)
+
+//命令执行完成时的事件，用于统计、追踪、日志
+type OpEvent struct {
+	Op      string //命令，如get、set、getkq
+	Key     string //批量命令为空
+	Server  string //server地址，key没有分配到server时为空
+	Start   time.Time
+	Latency time.Duration
+	Err     error //nil表示成功，可以用errors.Is(err, memcache.ErrNotFound)判断具体错误
+
+	Retries   int             //连接断开后重试的次数
+	ValueSize int             //写入或读取的value字节数，批量命令为0
+	Context   context.Context //WithContext绑定的context，设置了Tracer时为Tracer.Start的返回值
+}
+
+//命令执行完成时的回调，在执行命令的goroutine中同步调用，不能阻塞
+type Hook func(event *OpEvent)
+
+type ServerStats struct {
+	Address   string
+	Active    bool   //是否在hash环中，开启SetRemoveBadServer时不可用的server会被移出
+	TotalConn int    //已建立的连接数
+	IdleConn  int    //空闲连接数
+	WaitCount uint64 //连接数达到MaxConn时等待空闲连接的次数
+}
+
+type Stats struct {
+	Servers      []ServerStats
+	RingRebuilds uint64 //server状态变化导致hash环重建的次数
+}
+
+//添加命令执行完成时的回调，需要在执行命令前添加
+//重试多次的命令只回调一次，批量命令每个server回调一次
+func (this *Memcache) AddHook(hook Hook) { /*{{{*/
+	if hook == nil {
+		return
+	}
+	this.hooks = append(this.hooks, hook)
+} /*}}}*/
+
+//返回各server的连接池状态及hash环重建次数
+func (this *Memcache) Stats() *Stats { /*{{{*/
+	this.manager.Lock()
+	defer this.manager.Unlock()
+
+	stats := &Stats{
+		Servers:      make([]ServerStats, 0, len(this.manager.serverList)),
+		RingRebuilds: this.manager.rebuildCnt.Load(),
+	}
+	for _, s := range this.manager.serverList {
+		server_stats := ServerStats{Address: s.Address, Active: s.isActive}
+		if s.pool != nil {
+			server_stats.TotalConn, server_stats.IdleConn, server_stats.WaitCount = s.pool.stats()
+		}
+		stats.Servers = append(stats.Servers, server_stats)
+	}
+	return stats
+} /*}}}*/
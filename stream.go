@@ -0,0 +1,206 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+//大value分块存储：key:0..N保存各分块，key保存manifest(版本、总长度、分块大小、分块数、crc32)
+//每个分块前8字节为版本号，读取时校验版本号、长度及整体crc32，避免读到不同版本混合的数据
+//不超过分块大小的value直接以[]byte存储在key，不写入分块及manifest
+
+var defaultStreamChunkSize = 512 * 1024
+
+const (
+	streamVersionLen  = 8
+	streamManifestLen = 28
+
+	//memcached的item大小限制(-I)最大为1GB，manifest中的分块大小超过时视为损坏
+	streamMaxChunkSize = 1024 * 1024 * 1024
+)
+
+type streamManifest struct {
+	version   uint64
+	size      uint64
+	chunkSize uint32
+	chunkCnt  uint32
+	checksum  uint32
+}
+
+//设置SetStream的分块大小，需要小于memcached的item大小限制(-I，默认1MB)
+func (this *Memcache) SetStreamChunkSize(size int) { /*{{{*/
+	if size < 0 {
+		size = 0
+	}
+	this.streamChunkSize = size
+} /*}}}*/
+
+//从r读取size字节，分块写入key:0..N，全部成功后再写入key的manifest
+//写入过程中失败时key仍指向旧的数据，已写入的分块会随过期时间淘汰
+//size不超过分块大小时直接写入key
+func (this *Memcache) SetStream(key string, r io.Reader, size int64, expire ...uint32) error { /*{{{*/
+	if size < 0 {
+		return newOpError(OP_SET, key, nil, ErrInval)
+	}
+
+	chunk_size := this.streamChunkSize
+	if chunk_size == 0 {
+		chunk_size = defaultStreamChunkSize
+	}
+
+	var expiration uint32
+	if len(expire) > 0 {
+		expiration = expire[0]
+	}
+
+	if size <= int64(chunk_size) {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		_, err := this.SetItem(&Item{Key: key, Value: data, Flags: uint32(VALUE_TYPE_BYTE), Expiration: expiration})
+		return err
+	}
+
+	manifest := &streamManifest{
+		version:   uint64(time.Now().UnixNano()) ^ uint64(rand.Int63()),
+		size:      uint64(size),
+		chunkSize: uint32(chunk_size),
+		chunkCnt:  uint32((size + int64(chunk_size) - 1) / int64(chunk_size)),
+	}
+
+	buf := make([]byte, streamVersionLen+chunk_size)
+	binary.BigEndian.PutUint64(buf[:streamVersionLen], manifest.version)
+
+	crc := crc32.NewIEEE()
+	remain := size
+	for i := 0; i < int(manifest.chunkCnt); i++ {
+		n := int64(chunk_size)
+		if remain < n {
+			n = remain
+		}
+		chunk := buf[:streamVersionLen+int(n)]
+		if _, err := io.ReadFull(r, chunk[streamVersionLen:]); err != nil {
+			return err
+		}
+		crc.Write(chunk[streamVersionLen:])
+		remain -= n
+
+		item := &Item{Key: streamChunkKey(key, i), Value: chunk, Flags: uint32(VALUE_TYPE_BYTE), Expiration: expiration}
+		if _, err := this.SetItem(item); err != nil {
+			return err
+		}
+	}
+	manifest.checksum = crc.Sum32()
+
+	_, err := this.SetItem(&Item{Key: key, Value: reservedValue(manifest.encode()), Flags: uint32(VALUE_TYPE_STREAM), Expiration: expiration})
+	return err
+} /*}}}*/
+
+//读取SetStream写入的数据并依次写入w，返回写入的字节数
+//分块被淘汰、已被新版本覆盖或校验失败时返回ErrStreamBroken，此时w可能已写入部分数据
+func (this *Memcache) GetStream(key string, w io.Writer) (n int64, err error) { /*{{{*/
+	item, err := this.GetItem(key)
+	if err != nil {
+		return 0, err
+	}
+	//不超过分块大小的value直接存储
+	if value_type_t(item.Flags) == VALUE_TYPE_BYTE {
+		wn, err := w.Write(item.Value.([]byte))
+		return int64(wn), err
+	}
+	if value_type_t(item.Flags) != VALUE_TYPE_STREAM {
+		return 0, &TypeError{Key: key, Stored: value_type_t(item.Flags).String(), Want: VALUE_TYPE_STREAM.String()}
+	}
+
+	manifest, err := decodeStreamManifest(item.Value.([]byte))
+	if err != nil {
+		return 0, newOpError(OP_GET, key, nil, err)
+	}
+
+	//manifest已校验分块数与总长度一致，缓冲区不超过一个分块
+	buf_size := uint64(manifest.chunkSize)
+	if manifest.size < buf_size {
+		buf_size = manifest.size
+	}
+	buf := make([]byte, 0, streamVersionLen+int(buf_size))
+	crc := crc32.NewIEEE()
+	remain := manifest.size
+	for i := 0; i < int(manifest.chunkCnt); i++ {
+		chunk_key := streamChunkKey(key, i)
+
+		chunk, _, err := this.GetInto(chunk_key, buf)
+		if errors.Is(err, ErrNotFound) {
+			return n, newOpError(OP_GET, chunk_key, nil, ErrStreamBroken)
+		}
+		if err != nil {
+			return n, err
+		}
+		buf = chunk
+
+		expect := uint64(manifest.chunkSize)
+		if remain < expect {
+			expect = remain
+		}
+		if len(chunk) < streamVersionLen || uint64(len(chunk)-streamVersionLen) != expect ||
+			binary.BigEndian.Uint64(chunk[:streamVersionLen]) != manifest.version {
+			return n, newOpError(OP_GET, chunk_key, nil, ErrStreamBroken)
+		}
+
+		data := chunk[streamVersionLen:]
+		crc.Write(data)
+		remain -= expect
+
+		wn, err := w.Write(data)
+		n += int64(wn)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if remain != 0 || crc.Sum32() != manifest.checksum {
+		return n, newOpError(OP_GET, key, nil, ErrStreamBroken)
+	}
+
+	return n, nil
+} /*}}}*/
+
+func streamChunkKey(key string, i int) string { /*{{{*/
+	return key + ":" + strconv.Itoa(i)
+} /*}}}*/
+
+func (this *streamManifest) encode() []byte { /*{{{*/
+	b := make([]byte, streamManifestLen)
+	binary.BigEndian.PutUint64(b[0:8], this.version)
+	binary.BigEndian.PutUint64(b[8:16], this.size)
+	binary.BigEndian.PutUint32(b[16:20], this.chunkSize)
+	binary.BigEndian.PutUint32(b[20:24], this.chunkCnt)
+	binary.BigEndian.PutUint32(b[24:28], this.checksum)
+	return b
+} /*}}}*/
+
+func decodeStreamManifest(b []byte) (*streamManifest, error) { /*{{{*/
+	if len(b) != streamManifestLen {
+		return nil, ErrStreamBroken
+	}
+	manifest := &streamManifest{
+		version:   binary.BigEndian.Uint64(b[0:8]),
+		size:      binary.BigEndian.Uint64(b[8:16]),
+		chunkSize: binary.BigEndian.Uint32(b[16:20]),
+		chunkCnt:  binary.BigEndian.Uint32(b[20:24]),
+		checksum:  binary.BigEndian.Uint32(b[24:28]),
+	}
+	//分块大小不超过item大小限制，分块数与总长度一致，避免按损坏的manifest分配内存
+	if manifest.chunkSize == 0 || manifest.chunkSize > streamMaxChunkSize {
+		return nil, ErrStreamBroken
+	}
+	if uint64(manifest.chunkCnt) != (manifest.size+uint64(manifest.chunkSize)-1)/uint64(manifest.chunkSize) {
+		return nil, ErrStreamBroken
+	}
+	return manifest, nil
+} /*}}}*/
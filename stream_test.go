@@ -0,0 +1,105 @@
+package memcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestStream(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 3)
+	mc.SetStreamChunkSize(1000)
+
+	for _, size := range []int{0, 1, 999, 1000, 1001, 12345} {
+		data := make([]byte, size)
+		rand.Read(data)
+		if err := mc.SetStream("pdf", bytes.NewReader(data), int64(size), 60); err != nil {
+			t.Fatalf("size %d: SetStream: %v", size, err)
+		}
+
+		//不超过分块大小时直接存储，没有manifest
+		item, err := mc.GetItem("pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := size <= 1000; (value_type_t(item.Flags) == VALUE_TYPE_BYTE) != want {
+			t.Errorf("size %d: flags = %s, plain item %v", size, value_type_t(item.Flags), want)
+		}
+
+		var out bytes.Buffer
+		n, err := mc.GetStream("pdf", &out)
+		if err != nil || n != int64(size) || !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("size %d: GetStream = %d, %v", size, n, err)
+		}
+	}
+} /*}}}*/
+
+func TestStreamBroken(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 3)
+	mc.SetStreamChunkSize(1000)
+
+	data := make([]byte, 5000)
+	rand.Read(data)
+	if err := mc.SetStream("pdf", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	mc.Delete("pdf:3")
+	if _, err := mc.GetStream("pdf", &out); !errors.Is(err, ErrStreamBroken) {
+		t.Errorf("evicted chunk: err = %v, want ErrStreamBroken", err)
+	}
+	mc.Set("pdf:3", []byte("12345678xx"))
+	if _, err := mc.GetStream("pdf", &out); !errors.Is(err, ErrStreamBroken) {
+		t.Errorf("overwritten chunk: err = %v, want ErrStreamBroken", err)
+	}
+
+	mc.Set("plain", "x")
+	if _, err := mc.GetStream("plain", &out); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("string value: err = %v, want ErrTypeMismatch", err)
+	}
+	if _, err := mc.GetStream("missing", &out); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key: err = %v, want ErrNotFound", err)
+	}
+
+	//r的数据不足size
+	if err := mc.SetStream("short", bytes.NewReader([]byte("abc")), 10); err == nil {
+		t.Error("short reader: want error")
+	}
+} /*}}}*/
+
+//manifest中的分块大小、分块数与总长度不一致时不按其分配内存
+func TestStreamBadManifest(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	tests := []struct {
+		name     string
+		manifest streamManifest
+	}{
+		{"zero chunk size", streamManifest{size: 10, chunkSize: 0, chunkCnt: 1}},
+		{"huge chunk size", streamManifest{size: 1 << 40, chunkSize: 0xffffffff, chunkCnt: 256}},
+		{"too many chunks", streamManifest{size: 10, chunkSize: 5, chunkCnt: 0xffffffff}},
+		{"too few chunks", streamManifest{size: 1 << 40, chunkSize: 1024, chunkCnt: 1}},
+	}
+	for _, tt := range tests {
+		item := &Item{Key: "pdf", Value: reservedValue(tt.manifest.encode()), Flags: uint32(VALUE_TYPE_STREAM)}
+		if _, err := mc.SetItem(item); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if _, err := mc.GetStream("pdf", &out); !errors.Is(err, ErrStreamBroken) {
+			t.Errorf("%s: err = %v, want ErrStreamBroken", tt.name, err)
+		}
+	}
+
+	b := (&streamManifest{version: 1, size: 10, chunkSize: 4, chunkCnt: 3}).encode()
+	if manifest, err := decodeStreamManifest(b); err != nil || manifest.chunkCnt != 3 {
+		t.Errorf("decodeStreamManifest = %v, %v", manifest, err)
+	}
+	binary.BigEndian.PutUint32(b[20:24], 2)
+	if _, err := decodeStreamManifest(b); !errors.Is(err, ErrStreamBroken) {
+		t.Errorf("decodeStreamManifest chunkCnt 2 err = %v, want ErrStreamBroken", err)
+	}
+} /*}}}*/
@@ -0,0 +1,232 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//tag版本号的key
+const tagKeyPrefix = "tag:"
+
+//value后附加的tag信息：[tag长度uint16 tag 版本号uint64]... tag数uint16 原flags uint32 附加信息总长度uint32
+//附加在value之后，去掉后剩余部分即原value，GetMultiAs等可以直接按原flags处理
+const tagTrailerFixedLen = 10
+
+//写入key并记录各tag当前的版本号，InvalidateTags任一tag后Get、GetItem、GetAs、GetMultiAs等按未命中处理
+//tag版本号存储在memcached的tag:<tag>中，不存在时创建，读取各tag版本号使用一次批量读取
+//GetInto、GetAsync不检查tag，返回附加了tag信息的原始数据
+func (this *Memcache) SetWithTags(key string, value interface{}, expire uint32, tags ...string) (res bool, err error) { /*{{{*/
+	if len(tags) == 0 {
+		return this.Set(key, value, expire)
+	}
+
+	versions, err := this.tagVersions(tags, true)
+	if err != nil {
+		return false, err
+	}
+
+	var buf [20]byte
+	data, value_type := valueToByte(&buf, value)
+	if data == nil {
+		return false, newOpError(OP_SET, key, nil, ErrInvalValue)
+	}
+
+	item := &Item{
+		Key:        key,
+		Value:      reservedValue(appendTagTrailer(data, value_type, tags, versions)),
+		Flags:      uint32(VALUE_TYPE_TAGGED),
+		Expiration: expire,
+	}
+	if _, err = this.storeItem(OP_SET, item); err != nil {
+		return false, err
+	}
+	return true, nil
+} /*}}}*/
+
+//使带有任一tag的key失效，各tag的版本号加1
+//tag版本号不存在(被淘汰)时以当前时间重新创建，不能由incr从0创建，否则会重新使用旧版本号
+func (this *Memcache) InvalidateTags(tags ...string) (err error) { /*{{{*/
+	for _, tag := range tags {
+		key := tagKeyPrefix + tag
+		_, e := this.IncrementBy(key, 1, 0, CounterNoCreate)
+		if errors.Is(e, ErrNotFound) {
+			_, e = this.createCounter(key)
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+} /*}}}*/
+
+//读取tag版本号，create为true时创建不存在的版本号，否则不存在的版本号为0
+func (this *Memcache) tagVersions(tags []string, create bool) (versions []uint64, err error) { /*{{{*/
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagKeyPrefix + tag
+	}
+
+	res, err := this.getMultiValue(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	versions = make([]uint64, len(tags))
+	for i, key := range keys {
+		var value interface{}
+		if resp, ok := res[key]; ok {
+			value = resp.body
+		} else if create {
+			if value, err = this.createCounter(key); err != nil {
+				return nil, err
+			}
+		} else {
+			continue
+		}
+
+		version, ok := value.(int)
+		if !ok {
+			return nil, newOpError(OP_GET, key, nil, ErrInvalFormat)
+		}
+		versions[i] = uint64(version)
+	}
+	return versions, nil
+} /*}}}*/
+
+//检查tag版本号，有变化时返回ErrNotFound，否则还原为原value
+func (this *Memcache) untag(key string, res *response, format ...interface{}) error { /*{{{*/
+	tags, versions, ok := parseTagTrailer(res.value())
+	if !ok {
+		//不是SetWithTags写入的数据，同其它未知flags处理
+		res.body = nil
+		if _, err := formatValueFromByte(VALUE_TYPE_BIN, res.value(), format...); err != nil {
+			return newOpError(OP_GET, key, nil, err)
+		}
+		return nil
+	}
+
+	current, err := this.tagVersions(tags, false)
+	if err != nil {
+		return err
+	}
+	for i := range versions {
+		if current[i] != versions[i] {
+			//按未命中处理，GetAs等根据status判断，不再检查flags；保留cas用于覆盖失效的数据
+			res.header.status = STATUS_KEY_ENOENT
+			res.body = nil
+			return newOpError(OP_GET, key, nil, ErrNotFound)
+		}
+	}
+
+	restoreTagged(res)
+	res.body, err = formatValueFromByte(res.flags, res.value(), format...)
+	if err != nil {
+		return newOpError(OP_GET, key, nil, err)
+	}
+	return nil
+} /*}}}*/
+
+//批量读取的结果中tag版本号有变化的key按未命中删除，其它还原为原value，所有tag的版本号使用一次批量读取
+func (this *Memcache) untagMulti(res map[string]*response) error { /*{{{*/
+	var tagged []string
+	var all_tags []string
+	tag_index := make(map[string]int)
+	for key, resp := range res {
+		if resp.flags != VALUE_TYPE_TAGGED {
+			continue
+		}
+		tagged = append(tagged, key)
+		tags, _, _ := parseTagTrailer(resp.value())
+		for _, tag := range tags {
+			if _, ok := tag_index[tag]; !ok {
+				tag_index[tag] = len(all_tags)
+				all_tags = append(all_tags, tag)
+			}
+		}
+	}
+	if len(tagged) == 0 {
+		return nil
+	}
+
+	current, err := this.tagVersions(all_tags, false)
+	for _, key := range tagged {
+		resp := res[key]
+		tags, versions, ok := parseTagTrailer(resp.value())
+		if !ok {
+			resp.body = nil
+			continue
+		}
+		valid := err == nil
+		for i := 0; valid && i < len(tags); i++ {
+			valid = current[tag_index[tags[i]]] == versions[i]
+		}
+		if !valid {
+			delete(res, key)
+			continue
+		}
+
+		//同Connection.getMulti，map、struct等类型由调用方反序列化
+		restoreTagged(resp)
+		resp.body = nil
+		if resp.flags != VALUE_TYPE_BIN {
+			resp.body, _ = formatValueFromByte(resp.flags, resp.value())
+		}
+	}
+	return err
+} /*}}}*/
+
+func appendTagTrailer(data []byte, value_type value_type_t, tags []string, versions []uint64) []byte { /*{{{*/
+	size := tagTrailerFixedLen
+	for _, tag := range tags {
+		size += 2 + len(tag) + 8
+	}
+
+	b := make([]byte, 0, len(data)+size)
+	b = append(b, data...)
+	for i, tag := range tags {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(tag)))
+		b = append(b, tag...)
+		b = binary.BigEndian.AppendUint64(b, versions[i])
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(tags)))
+	b = binary.BigEndian.AppendUint32(b, uint32(value_type))
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	return b
+} /*}}}*/
+
+func parseTagTrailer(data []byte) (tags []string, versions []uint64, ok bool) { /*{{{*/
+	if len(data) < tagTrailerFixedLen {
+		return nil, nil, false
+	}
+	size := int(binary.BigEndian.Uint32(data[len(data)-4:]))
+	if size < tagTrailerFixedLen || size > len(data) {
+		return nil, nil, false
+	}
+	trailer := data[len(data)-size:]
+	cnt := int(binary.BigEndian.Uint16(trailer[size-10:]))
+
+	tags = make([]string, 0, cnt)
+	versions = make([]uint64, 0, cnt)
+	b := trailer[:size-tagTrailerFixedLen]
+	for i := 0; i < cnt; i++ {
+		if len(b) < 2 {
+			return nil, nil, false
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+n+8 {
+			return nil, nil, false
+		}
+		tags = append(tags, string(b[2:2+n]))
+		versions = append(versions, binary.BigEndian.Uint64(b[2+n:]))
+		b = b[2+n+8:]
+	}
+	return tags, versions, len(b) == 0
+} /*}}}*/
+
+//去掉tag信息，flags还原为原value的flags
+func restoreTagged(res *response) { /*{{{*/
+	data := res.value()
+	size := int(binary.BigEndian.Uint32(data[len(data)-4:]))
+	res.flags = value_type_t(binary.BigEndian.Uint32(data[len(data)-8:]))
+	res.bodyByte = res.bodyByte[:len(res.bodyByte)-size]
+} /*}}}*/
@@ -0,0 +1,141 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+)
+
+type tagUser struct {
+	Name string
+}
+
+func TestSetWithTags(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 2)
+
+	if _, err := mc.SetWithTags("str", "value", 0, "user:1", "org:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.SetWithTags("int", 42, 0, "org:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.SetWithTags("struct", tagUser{Name: "a"}, 0, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	//没有tag时同Set
+	if _, err := mc.SetWithTags("plain", "plain", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	//读取时还原为原value、原flags
+	if value, _, err := mc.Get("str"); err != nil || value != "value" {
+		t.Errorf("Get str = %v, %v", value, err)
+	}
+	if value, _, err := GetAs[int](mc, "int"); err != nil || value != 42 {
+		t.Errorf("GetAs int = %v, %v", value, err)
+	}
+	var u tagUser
+	if _, _, err := mc.Get("struct", &u); err != nil || u.Name != "a" {
+		t.Errorf("Get struct = %v, %v", u, err)
+	}
+	item, err := mc.GetItem("struct")
+	if err != nil || item.Flags != uint32(VALUE_TYPE_BIN) {
+		t.Errorf("GetItem struct = %v, %v", item, err)
+	}
+	values, err := GetMultiAs[string](mc, []string{"str", "plain"})
+	if err != nil || values["str"] != "value" || values["plain"] != "plain" {
+		t.Errorf("GetMultiAs = %v, %v", values, err)
+	}
+} /*}}}*/
+
+func TestInvalidateTags(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 2)
+
+	mc.SetWithTags("a", "a", 0, "user:1", "org:1")
+	mc.SetWithTags("b", "b", 0, "org:1")
+	mc.SetWithTags("c", "c", 0, "user:2")
+
+	if err := mc.InvalidateTags("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := mc.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get a err = %v, want ErrNotFound", err)
+	}
+	if _, err := mc.GetItem("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetItem a err = %v, want ErrNotFound", err)
+	}
+	values, err := GetMultiAs[string](mc, []string{"a", "b", "c"})
+	if err != nil || len(values) != 2 || values["b"] != "b" || values["c"] != "c" {
+		t.Errorf("GetMultiAs = %v, %v", values, err)
+	}
+
+	//版本号不存在的tag同样创建，写入的key在下次InvalidateTags后失效
+	if err := mc.InvalidateTags("org:1", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := mc.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get b err = %v, want ErrNotFound", err)
+	}
+	mc.SetWithTags("d", "d", 0, "new")
+	if value, _, err := mc.Get("d"); err != nil || value != "d" {
+		t.Errorf("Get d = %v, %v", value, err)
+	}
+	mc.InvalidateTags("new")
+	if _, _, err := mc.Get("d"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get d err = %v, want ErrNotFound", err)
+	}
+} /*}}}*/
+
+//tag版本号被淘汰后不能从0重新开始，否则之前在版本号为0时写入的key重新有效
+func TestInvalidateTagsEvicted(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	mc.SetWithTags("a", "a", 0, "tag")
+	for i := 0; i < 2; i++ {
+		if _, err := mc.Delete(tagKeyPrefix + "tag"); err != nil {
+			t.Fatal(err)
+		}
+		//版本号被淘汰时带有该tag的key失效
+		if _, _, err := mc.Get("a"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("round %d: Get a err = %v, want ErrNotFound", i, err)
+		}
+		if err := mc.InvalidateTags("tag"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := mc.Get("a"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("round %d: Get a after invalidate err = %v, want ErrNotFound", i, err)
+		}
+
+		value, _, err := mc.Get(tagKeyPrefix + "tag")
+		if err != nil || value == 0 {
+			t.Fatalf("round %d: tag version = %v, %v, want a new version", i, value, err)
+		}
+		mc.SetWithTags("a", "a", 0, "tag")
+	}
+} /*}}}*/
+
+func TestUntag(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+	mc.SetWithTags("key", "value", 0, "t1", "t2")
+
+	res, err := mc.get("key")
+	if err != nil || res.body != "value" || res.flags != VALUE_TYPE_STRING {
+		t.Fatalf("get = %v, %v", res, err)
+	}
+
+	//tag信息不完整的数据不是SetWithTags写入的，按未知flags处理返回原始数据
+	item := &Item{Key: "broken", Value: reservedValue("value"), Flags: uint32(VALUE_TYPE_TAGGED)}
+	if _, err := mc.SetItem(item); err != nil {
+		t.Fatal(err)
+	}
+	res, err = mc.get("broken")
+	if !errors.Is(err, ErrNoFormat) || string(res.value()) != "value" {
+		t.Errorf("get broken = %v, %v, want ErrNoFormat", res, err)
+	}
+
+	//任一tag版本号变化时按未命中处理，保留cas
+	mc.InvalidateTags("t2")
+	res, err = mc.get("key")
+	if !errors.Is(err, ErrNotFound) || res == nil || res.header.cas == 0 || res.body != nil {
+		t.Errorf("get after invalidate = %v, %v", res, err)
+	}
+} /*}}}*/
@@ -0,0 +1,128 @@
+package memcache
+
+import (
+	"context"
+	"time"
+)
+
+//命令的追踪，可以用于接入OpenTelemetry等，参考子包github.com/pangudashu/memcache/otel
+type Tracer interface {
+	//命令开始时调用，返回的context在End时传入，批量命令中各server的子命令以其为父context
+	Start(ctx context.Context, op string, key string) context.Context
+	//命令完成时调用
+	End(ctx context.Context, event *OpEvent)
+}
+
+//一次命令调用的状态
+type opCall struct {
+	ctx    context.Context
+	opcode opcode_t
+	key    string
+	server *Server
+	start  time.Time
+	tries  int //重试循环的次数
+	size   int //value字节数
+}
+
+//返回绑定ctx的Memcache，与原Memcache共用连接池、配置，执行命令时ctx传给Tracer、Hook
+//ctx只用于追踪，不会取消正在执行的命令
+func (this *Memcache) WithContext(ctx context.Context) *Memcache { /*{{{*/
+	return &Memcache{client: this.client, ctx: ctx, ns: this.ns}
+} /*}}}*/
+
+//设置命令的追踪，需要在执行命令前设置
+func (this *Memcache) SetTracer(tracer Tracer) { /*{{{*/
+	this.tracer = tracer
+} /*}}}*/
+
+//命令开始，需要与finish成对调用
+func (this *Memcache) begin(ctx context.Context, opcode opcode_t, key string, server *Server) opCall { /*{{{*/
+	call := opCall{ctx: ctx, opcode: opcode, key: key, server: server}
+	if len(this.hooks) == 0 && this.tracer == nil && this.log.slowThreshold.Load() == 0 {
+		return call
+	}
+
+	call.start = time.Now()
+	if this.tracer != nil {
+		if call.ctx == nil {
+			call.ctx = context.Background()
+		}
+		call.ctx = this.tracer.Start(call.ctx, opcode.String(), key)
+	}
+	return call
+} /*}}}*/
+
+//命令完成后调用hook、Tracer并输出日志，使用defer调用时err为返回值的指针
+func (this *Memcache) finish(call *opCall, err *error) { /*{{{*/
+	if call.tries > 0 {
+		this.logRetry(call, err)
+	}
+	if call.start.IsZero() {
+		return
+	}
+
+	event := call.event(err)
+	if threshold := time.Duration(this.log.slowThreshold.Load()); threshold > 0 && event.Latency >= threshold {
+		this.log.log(LogWarn, "memcache: slow command", "op", event.Op, "key", event.Key, "server", event.Server, "latency", event.Latency, "err", event.Err)
+	}
+	for _, hook := range this.hooks {
+		hook(event)
+	}
+	if this.tracer != nil {
+		this.tracer.End(call.ctx, event)
+	}
+} /*}}}*/
+
+//连接断开后重试过的命令
+func (this *Memcache) logRetry(call *opCall, err *error) { /*{{{*/
+	if this.log.get() == nil {
+		return
+	}
+	event := call.event(err)
+	this.log.log(LogWarn, "memcache: retried on bad connection", "op", event.Op, "key", event.Key, "server", event.Server, "retries", event.Retries, "err", event.Err)
+} /*}}}*/
+
+//批量命令整体的追踪，只调用Tracer，各server的子命令使用begin、finish
+func (this *Memcache) finishSpan(call *opCall, err *error) { /*{{{*/
+	if this.tracer != nil {
+		this.tracer.End(call.ctx, call.event(err))
+	}
+} /*}}}*/
+
+//SetMulti、DeleteMulti等返回map的批量命令，Err为其中一个失败key的错误
+func (this *Memcache) finishMultiSpan(call *opCall, errs map[string]error) { /*{{{*/
+	if this.tracer == nil {
+		return
+	}
+	var err error
+	for _, e := range errs {
+		err = e
+		break
+	}
+	this.finishSpan(call, &err)
+} /*}}}*/
+
+func (this *opCall) event(err *error) *OpEvent { /*{{{*/
+	event := &OpEvent{
+		Op:        this.opcode.String(),
+		Key:       this.key,
+		Start:     this.start,
+		Latency:   time.Since(this.start),
+		ValueSize: this.size,
+		Context:   this.ctx,
+	}
+	if this.server != nil {
+		event.Server = this.server.Address
+	}
+	if err != nil {
+		event.Err = *err
+	}
+	//tries为重试循环结束时的次数，循环没有break时等于badTryCnt
+	if this.tries > 0 {
+		event.Retries = this.tries
+		if event.Retries > badTryCnt-1 {
+			event.Retries = badTryCnt - 1
+		}
+	}
+	return event
+} /*}}}*/
@@ -0,0 +1,125 @@
+package memcache
+
+import (
+	"reflect"
+)
+
+//读取key并返回T类型的value，存储的value类型与T不一致时返回*TypeError
+//
+//	count, cas, err := memcache.GetAs[int64](mc, "count")
+func GetAs[T any](mc *Memcache, key string) (value T, cas uint64, err error) { /*{{{*/
+	var zero T
+	want := valueTypeOf(zero)
+
+	res, err := mc.get(key, &value)
+	if res == nil || res.header.status != STATUS_SUCCESS || res.flags == VALUE_TYPE_NEGATIVE {
+		return zero, 0, err
+	}
+	if res.flags != want {
+		return zero, res.header.cas, newTypeError(key, res.flags, zero)
+	}
+	if err != nil {
+		return zero, res.header.cas, err
+	}
+
+	//map、struct等类型已直接反序列化到value
+	if want != VALUE_TYPE_BIN {
+		v, ok := res.body.(T)
+		if !ok {
+			return zero, res.header.cas, ErrInvalFormat
+		}
+		value = v
+	}
+
+	return value, res.header.cas, nil
+} /*}}}*/
+
+//存储T类型的value，参数同Set
+func SetAs[T any](mc *Memcache, key string, value T, expire ...uint32) (res bool, err error) { /*{{{*/
+	return mc.Set(key, value, expire...)
+} /*}}}*/
+
+//批量读取T类型的value，未命中的key不在返回结果中
+//部分key类型不一致或server出错时values仍包含其它key的结果(不包含出错的key)，err为遇到的第一个错误
+//因此err不为nil时values可能不为空，需要按key检查
+func GetMultiAs[T any](mc *Memcache, keys []string) (values map[string]T, err error) { /*{{{*/
+	var zero T
+	want := valueTypeOf(zero)
+
+	resps, err := mc.getMulti(keys)
+
+	values = make(map[string]T, len(resps))
+	for _, key := range keys {
+		res, ok := resps[key]
+		if !ok || res.header.status != STATUS_SUCCESS {
+			continue
+		}
+
+		var value T
+		var e error
+		switch {
+		case res.flags != want:
+			e = newTypeError(key, res.flags, zero)
+		case want == VALUE_TYPE_BIN:
+			e = ByteToStruct(res.value(), &value)
+		default:
+			if value, ok = res.body.(T); !ok {
+				e = ErrInvalFormat
+			}
+		}
+
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		values[key] = value
+	}
+
+	return values, err
+} /*}}}*/
+
+//与getValueTypeByte对应的类型标记
+func valueTypeOf(value interface{}) value_type_t { /*{{{*/
+	switch value.(type) {
+	case []byte:
+		return VALUE_TYPE_BYTE
+	case int:
+		return VALUE_TYPE_INT
+	case int8:
+		return VALUE_TYPE_INT8
+	case int16:
+		return VALUE_TYPE_INT16
+	case int32:
+		return VALUE_TYPE_INT32
+	case int64:
+		return VALUE_TYPE_INT64
+	case uint8:
+		return VALUE_TYPE_UINT8
+	case uint16:
+		return VALUE_TYPE_UINT16
+	case uint32:
+		return VALUE_TYPE_UINT32
+	case uint64:
+		return VALUE_TYPE_UINT64
+	case float32:
+		return VALUE_TYPE_FLOAT32
+	case float64:
+		return VALUE_TYPE_FLOAT64
+	case string:
+		return VALUE_TYPE_STRING
+	case bool:
+		return VALUE_TYPE_BOOL
+	default:
+		return VALUE_TYPE_BIN
+	}
+} /*}}}*/
+
+func newTypeError(key string, stored value_type_t, want interface{}) error { /*{{{*/
+	want_name := "<nil>"
+	if t := reflect.TypeOf(want); t != nil {
+		want_name = t.String()
+	}
+	return &TypeError{Key: key, Stored: stored.String(), Want: want_name}
+} /*}}}*/
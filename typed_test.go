@@ -0,0 +1,94 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+)
+
+type typedUser struct {
+	Name string
+	Age  int
+	Tags []string
+}
+
+func TestGetAs(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+
+	if _, err := SetAs[int64](mc, "count", 42); err != nil {
+		t.Fatal(err)
+	}
+	if count, cas, err := GetAs[int64](mc, "count"); err != nil || count != 42 || cas == 0 {
+		t.Errorf("GetAs[int64] = %d, %d, %v", count, cas, err)
+	}
+
+	//struct直接反序列化到T
+	user := typedUser{Name: "tom", Age: 18, Tags: []string{"a", "b"}}
+	if _, err := SetAs(mc, "user", user); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := GetAs[typedUser](mc, "user")
+	if err != nil || got.Name != user.Name || got.Age != user.Age || len(got.Tags) != 2 || got.Tags[1] != "b" {
+		t.Errorf("GetAs[typedUser] = %+v, %v", got, err)
+	}
+
+	//类型不一致
+	var type_err *TypeError
+	if value, _, err := GetAs[string](mc, "count"); !errors.Is(err, ErrTypeMismatch) || !errors.As(err, &type_err) || value != "" {
+		t.Errorf("GetAs[string] = %q, %v, want ErrTypeMismatch", value, err)
+	} else if type_err.Key != "count" || type_err.Stored != VALUE_TYPE_INT64.String() || type_err.Want != "string" {
+		t.Errorf("TypeError = %+v", type_err)
+	}
+	if _, _, err := GetAs[int](mc, "count"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("GetAs[int] err = %v, want ErrTypeMismatch", err)
+	}
+	if _, _, err := GetAs[int64](mc, "user"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("GetAs[int64] on struct err = %v, want ErrTypeMismatch", err)
+	}
+
+	if _, _, err := GetAs[int64](mc, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAs missing err = %v, want ErrNotFound", err)
+	}
+} /*}}}*/
+
+func TestGetMultiAs(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 3)
+
+	users := map[string]typedUser{
+		"user_1": {Name: "tom", Age: 18},
+		"user_2": {Name: "jerry", Age: 20},
+		"user_3": {Name: "spike", Age: 30},
+	}
+	for key, user := range users {
+		if _, err := SetAs(mc, key, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values, err := GetMultiAs[typedUser](mc, []string{"user_1", "user_2", "user_3", "missing"})
+	if err != nil || len(values) != 3 {
+		t.Fatalf("GetMultiAs = %v, %v", values, err)
+	}
+	for key, user := range users {
+		if values[key].Name != user.Name || values[key].Age != user.Age {
+			t.Errorf("GetMultiAs[%s] = %+v, want %+v", key, values[key], user)
+		}
+	}
+
+	//一个key类型不一致时返回其它key的结果及该错误
+	if _, err := mc.Set("user_2", "not a user"); err != nil {
+		t.Fatal(err)
+	}
+	values, err = GetMultiAs[typedUser](mc, []string{"user_1", "user_2", "user_3"})
+	var type_err *TypeError
+	if !errors.Is(err, ErrTypeMismatch) || !errors.As(err, &type_err) || type_err.Key != "user_2" {
+		t.Errorf("GetMultiAs err = %v, want ErrTypeMismatch for user_2", err)
+	}
+	if _, ok := values["user_2"]; ok || len(values) != 2 || values["user_1"].Name != "tom" || values["user_3"].Name != "spike" {
+		t.Errorf("GetMultiAs partial values = %v", values)
+	}
+
+	strs, err := GetMultiAs[string](mc, []string{"user_2"})
+	if err != nil || strs["user_2"] != "not a user" {
+		t.Errorf("GetMultiAs[string] = %v, %v", strs, err)
+	}
+} /*}}}*/
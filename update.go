@@ -0,0 +1,107 @@
+package memcache
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+type UpdateOptions struct {
+	MaxRetry   int           //cas冲突时最大重试次数，默认10
+	Backoff    time.Duration //重试等待时间基数，每次翻倍并随机抖动，默认5ms
+	Expiration uint32        //写入时的过期时间
+	Format     interface{}   //存储的value为map、结构体时用于反序列化的类型，如&User{}
+}
+
+var (
+	defaultUpdateRetry   = 10
+	defaultUpdateBackoff = time.Millisecond * 5
+)
+
+//读取-修改-写入，使用cas保证并发安全，数据被其它client更新时重新读取并调用fn
+//key不存在时fn的参数为nil，并使用Add写入，负缓存标记、tag已失效的数据同样按不存在处理
+//fn返回error时终止更新并返回该错误，成功返回写入后的cas
+//
+//	cas, err := mc.Update("counter", func(old interface{}) (interface{}, error) {
+//		if old == nil {
+//			return int64(1), nil
+//		}
+//		return old.(int64) + 1, nil
+//	}, nil)
+func (this *Memcache) Update(key string, fn func(old interface{}) (interface{}, error), opts *UpdateOptions) (cas uint64, err error) { /*{{{*/
+	max_retry := defaultUpdateRetry
+	backoff := defaultUpdateBackoff
+	item := &Item{Key: key}
+
+	if opts != nil {
+		if opts.MaxRetry > 0 {
+			max_retry = opts.MaxRetry
+		}
+		if opts.Backoff > 0 {
+			backoff = opts.Backoff
+		}
+		item.Expiration = opts.Expiration
+
+		if opts.Format != nil && reflect.TypeOf(opts.Format).Kind() != reflect.Ptr {
+			return 0, ErrInvalFormat
+		}
+	}
+
+	for i := 0; i <= max_retry; i++ {
+		if i > 0 {
+			time.Sleep(updateBackoff(backoff, i))
+		}
+
+		var format []interface{}
+		if opts != nil && opts.Format != nil {
+			//每次重试使用新的对象，避免残留上次反序列化的字段
+			format = append(format, reflect.New(reflect.TypeOf(opts.Format).Elem()).Interface())
+		}
+
+		old, stale, e := this.getItem(key, format...)
+		if e != nil && !errors.Is(e, ErrNotFound) {
+			return 0, e
+		}
+
+		var old_value interface{}
+		if old != nil {
+			old_value = old.Value
+		}
+
+		new_value, e := fn(old_value)
+		if e != nil {
+			return 0, e
+		}
+
+		item.Value = new_value
+		switch {
+		case old != nil:
+			item.CAS = old.CAS
+			cas, err = this.CompareAndSwap(item)
+		case stale != 0:
+			//负缓存标记、tag已失效的数据仍存储在服务端，Add总是失败，使用读取时的cas覆盖
+			item.CAS = stale
+			cas, err = this.CompareAndSwap(item)
+		default:
+			item.CAS = 0
+			cas, err = this.AddItem(item)
+		}
+
+		//ErrKeyExists: 数据已被更新或key已被创建，ErrNotFound: key已被删除
+		if err == nil || !(errors.Is(err, ErrKeyExists) || errors.Is(err, ErrNotFound)) {
+			return cas, err
+		}
+	}
+
+	return 0, err
+} /*}}}*/
+
+//指数退避，在[0, backoff*2^(n-1))之间随机
+func updateBackoff(backoff time.Duration, n int) time.Duration { /*{{{*/
+	if n > 10 {
+		n = 10
+	}
+	max := int64(backoff) << uint(n-1)
+	return time.Duration(rand.Int63n(max) + 1)
+} /*}}}*/
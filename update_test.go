@@ -0,0 +1,49 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+)
+
+//负缓存标记、tag已失效的数据按不存在处理，使用cas覆盖，不需要等待重试
+func TestUpdateStaleEntry(t *testing.T) { /*{{{*/
+	mc, _ := newFakeClient(t, 1)
+	mc.SetNegativeTTL(60)
+
+	var dst string
+	err := mc.GetOrLoad("negative", &dst, 60, func() (interface{}, error) {
+		return nil, ErrNotFound
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetOrLoad err = %v", err)
+	}
+
+	if _, err := mc.SetWithTags("tagged", "old", 60, "tag"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.InvalidateTags("tag"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"negative", "tagged"} {
+		calls := 0
+		_, err := mc.Update(key, func(old interface{}) (interface{}, error) {
+			calls++
+			if old != nil {
+				t.Errorf("%s: old = %v, want nil", key, old)
+			}
+			return "new", nil
+		}, &UpdateOptions{MaxRetry: 1})
+		if err != nil {
+			t.Fatalf("%s: Update err = %v", key, err)
+		}
+		if calls != 1 {
+			t.Errorf("%s: fn called %d times, want 1", key, calls)
+		}
+
+		value, _, err := mc.Get(key)
+		if err != nil || value != "new" {
+			t.Errorf("%s: Get = %v, %v, want new", key, value, err)
+		}
+	}
+} /*}}}*/
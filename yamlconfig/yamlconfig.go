@@ -0,0 +1,38 @@
+//YAML格式的server列表配置文件，单独的包避免memcache依赖gopkg.in/yaml.v3
+//
+//	servers:
+//	  - address: 10.0.1.5:11211
+//	    weight: 50
+//	    max_conn: 64
+//	    init_conn: 4
+//	    idle_time: 30m
+//	dial_timeout: 1s
+//	read_timeout: 500ms
+//	write_timeout: 500ms
+//
+//	mc, err := yamlconfig.NewMemcache("/etc/memcached/servers.yaml")
+package yamlconfig
+
+import (
+	"bytes"
+
+	"github.com/pangudashu/memcache"
+	"gopkg.in/yaml.v3"
+)
+
+//从YAML配置文件读取server列表创建Memcache，文件变化时重新加载，同memcache.NewMemcacheFromFile
+func NewMemcache(path string) (*memcache.Memcache, error) { /*{{{*/
+	return memcache.NewMemcacheFromFileWith(path, Decode)
+} /*}}}*/
+
+//读取并校验YAML配置文件，错误可以用errors.Is(err, memcache.ErrInvalConfig)判断
+func Load(path string) (*memcache.FileConfig, error) { /*{{{*/
+	return memcache.LoadFileConfigWith(path, Decode)
+} /*}}}*/
+
+//按YAML格式解析，未知字段返回错误
+func Decode(data []byte, config *memcache.FileConfig) error { /*{{{*/
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	return decoder.Decode(config)
+} /*}}}*/
@@ -0,0 +1,76 @@
+package yamlconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pangudashu/memcache"
+	"github.com/pangudashu/memcache/internal/fakemc"
+)
+
+func writeFile(t *testing.T, content string) string { /*{{{*/
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "servers.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+} /*}}}*/
+
+func TestLoad(t *testing.T) { /*{{{*/
+	path := writeFile(t, `
+servers:
+  - address: 10.0.0.1:11211
+    weight: 10
+    max_conn: 8
+    init_conn: 2
+    idle_time: 30m
+  - address: "[fd00::6]:11211"
+read_timeout: 500ms
+`)
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := config.Servers[0]
+	if len(config.Servers) != 2 || s.Weight != 10 || s.MaxConn != 8 || s.InitConn != 2 || time.Duration(s.IdleTime) != time.Minute*30 || time.Duration(config.ReadTimeout) != time.Millisecond*500 {
+		t.Fatalf("config = %+v", config)
+	}
+	if config.Servers[1].Address != "[fd00::6]:11211" {
+		t.Fatalf("address = %s", config.Servers[1].Address)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown field", "servers:\n  - address: 10.0.0.1:11211\ntimeout: 1s\n"},
+		{"no servers", "servers: []\n"},
+		{"invalid duration", "servers:\n  - address: 10.0.0.1:11211\n    idle_time: 30\n"},
+		{"syntax", "servers: [\n"},
+	}
+	for _, tt := range tests {
+		if _, err := Load(writeFile(t, tt.content)); !errors.Is(err, memcache.ErrInvalConfig) {
+			t.Errorf("%s: err = %v, want ErrInvalConfig", tt.name, err)
+		}
+	}
+} /*}}}*/
+
+func TestNewMemcache(t *testing.T) { /*{{{*/
+	s := fakemc.New(t)
+	mc, err := NewMemcache(writeFile(t, "servers:\n  - address: "+s.Addr()+"\n    init_conn: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+
+	if _, err := mc.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, err := mc.Get("key"); err != nil || value != "value" {
+		t.Fatalf("Get = %v, %v", value, err)
+	}
+} /*}}}*/